@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const testTimeout = 5 * time.Second
+
+type testClient struct {
+	gs     *gamelogic.GameState
+	signer pubsub.PublishOption
+	pauses chan routing.PlayingState
+}
+
+func newSigner(t *testing.T, keys pubsub.Keys, name string) pubsub.PublishOption {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys[name] = public
+	return pubsub.WithSigner(name, private)
+}
+
+// join subscribes a client to pauses and moves the way main does, and to
+// wars too if fightWars is set.
+func join(t *testing.T, broker pubsub.Broker, keys pubsub.Keys, username string, fightWars bool) *testClient {
+	t.Helper()
+	c := &testClient{
+		gs:     gamelogic.NewGameState(username),
+		signer: newSigner(t, keys, username),
+		pauses: make(chan routing.PlayingState, 10),
+	}
+	subscribed := func(sub *pubsub.Subscription, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(sub.Close)
+	}
+	ctx := context.Background()
+
+	handlePause := handlerPause(c.gs)
+	subscribed(pubsub.SubscribeJSON(
+		ctx,
+		broker,
+		routing.ExchangePerilDirect,
+		fmt.Sprintf("%s.%s", routing.PauseKey, username),
+		routing.PauseKey,
+		pubsub.Transient,
+		func(ps routing.PlayingState) pubsub.AckType {
+			acktype := handlePause(ps)
+			c.pauses <- ps
+			return acktype
+		},
+		pubsub.WithVerification(keys, routing.SignedByServer),
+	))
+	subscribed(pubsub.SubscribeJSONErr(
+		ctx,
+		broker,
+		routing.ExchangePerilTopic,
+		fmt.Sprintf("%s.%s", routing.ArmyMovesPrefix, username),
+		fmt.Sprintf("%s.%s", routing.ArmyMovesPrefix, "*"),
+		pubsub.Transient,
+		handlerMove(c.gs, broker, c.signer),
+		pubsub.WithWorkers(moveWorkers),
+		pubsub.WithOrderedKey(pubsub.ByRoutingKey),
+		pubsub.WithDedup(pubsub.NewDedup(dedupSize, dedupWindow)),
+		pubsub.WithVerification(keys, pubsub.ByValue(func(mv gamelogic.ArmyMove) string {
+			return mv.Player.Username
+		})),
+	))
+	if fightWars {
+		subscribed(pubsub.SubscribeJSONErr(
+			ctx,
+			broker,
+			routing.ExchangePerilTopic,
+			routing.WarRecognitionsPrefix,
+			fmt.Sprintf("%s.%s", routing.WarRecognitionsPrefix, "*"),
+			pubsub.Durable,
+			handlerWar(c.gs, newFoughtWars(dedupSize), broker, c.signer),
+			pubsub.WithDedup(pubsub.NewDedup(dedupSize, dedupWindow)),
+			pubsub.WithVerification(keys, pubsub.ByValue(func(rw gamelogic.RecognitionOfWar) string {
+				return rw.Defender.Username
+			})),
+		))
+	}
+	return c
+}
+
+func (c *testClient) waitForPause(t *testing.T, want routing.PlayingState) {
+	t.Helper()
+	select {
+	case ps := <-c.pauses:
+		if ps != want {
+			t.Fatalf("%s got pause state %+v, want %+v", c.gs.GetUsername(), ps, want)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("%s got no pause state", c.gs.GetUsername())
+	}
+}
+
+func TestPauseMoveWar(t *testing.T) {
+	broker := pubsub.NewMemoryBroker()
+	defer broker.Close()
+	if err := pubsub.DeclareTopology(broker, routing.Topology); err != nil {
+		t.Fatal(err)
+	}
+	keys := pubsub.Keys{}
+	server := newSigner(t, keys, routing.ServerSigner)
+	alice := join(t, broker, keys, "alice", true)
+	bob := join(t, broker, keys, "bob", false)
+
+	logs := make(chan routing.GameLog, 10)
+	sub, err := pubsub.SubscribeGob(
+		context.Background(),
+		broker,
+		routing.ExchangePerilTopic,
+		routing.GameLogSlug,
+		routing.GameLogSlug+".*",
+		pubsub.Durable,
+		func(gl routing.GameLog) pubsub.AckType {
+			logs <- gl
+			return pubsub.Ack
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	if err := alice.gs.CommandSpawn([]string{"spawn", "asia", gamelogic.RankArtillery}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.gs.CommandSpawn([]string{"spawn", "europe", gamelogic.RankInfantry}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server pauses the game, and nobody can move until it resumes.
+	for _, ps := range []routing.PlayingState{{IsPaused: true, Seq: 1}, {IsPaused: false, Seq: 2}} {
+		err := pubsub.PublishJSON(broker, routing.ExchangePerilDirect, routing.PauseKey, ps, server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alice.waitForPause(t, ps)
+		bob.waitForPause(t, ps)
+		if ps.IsPaused {
+			if _, err := alice.gs.CommandMove([]string{"move", "europe", "1"}); err == nil {
+				t.Fatal("moved while the game was paused")
+			}
+		}
+	}
+
+	// Alice moves artillery onto Bob's infantry. Bob recognizes the war
+	// and Alice fights and wins it.
+	move, err := alice.gs.CommandMove([]string{"move", "europe", "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moveKey := fmt.Sprintf("%s.%s", routing.ArmyMovesPrefix, "alice")
+	err = pubsub.PublishJSON(broker, routing.ExchangePerilTopic, moveKey, move, compressSnapshots, alice.signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case gl := <-logs:
+		if gl.Username != "alice" || gl.Message != "alice won a war against bob" {
+			t.Errorf("got game log %q from %s", gl.Message, gl.Username)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the war was never fought")
+	}
+	if _, ok := alice.gs.GetUnit(1); !ok {
+		t.Error("the winner lost their artillery")
+	}
+}
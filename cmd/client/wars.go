@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+type warResult struct {
+	outcome       gamelogic.WarOutcome
+	winner, loser string
+}
+
+// foughtWars remembers the result of the most recent wars by message ID, so
+// a war retried because its game log could not be published is not fought
+// again and does not remove units twice.
+type foughtWars struct {
+	mu      sync.Mutex
+	size    int
+	results map[string]warResult
+	order   []string
+}
+
+func newFoughtWars(size int) *foughtWars {
+	return &foughtWars{size: size, results: map[string]warResult{}}
+}
+
+// fight applies rw to gs unless the war with id was already fought.
+func (f *foughtWars) fight(gs *gamelogic.GameState, id string, rw gamelogic.RecognitionOfWar) warResult {
+	if id == "" {
+		outcome, winner, loser := gs.HandleWar(rw)
+		return warResult{outcome: outcome, winner: winner, loser: loser}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if result, ok := f.results[id]; ok {
+		return result
+	}
+	outcome, winner, loser := gs.HandleWar(rw)
+	result := warResult{outcome: outcome, winner: winner, loser: loser}
+	f.results[id] = result
+	f.order = append(f.order, id)
+	if len(f.order) > f.size {
+		delete(f.results, f.order[0])
+		f.order = f.order[1:]
+	}
+	return result
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// keygen creates a key pair for each name given, writing the private key to
+// <name>.key and adding the public key to the shared keys file.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Printf("usage: %s <name>...\n", os.Args[0])
+		os.Exit(2)
+	}
+	path := routing.KeysFile()
+	keys, err := pubsub.LoadKeys(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		keys, err = pubsub.Keys{}, nil
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Check every name first so a clash does not leave a private key
+	// without its public key.
+	for _, name := range os.Args[1:] {
+		if _, err := os.Stat(routing.PrivateKeyFile(name)); err == nil {
+			log.Fatalf("%s already exists", routing.PrivateKeyFile(name))
+		}
+	}
+	for _, name := range os.Args[1:] {
+		public, private, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keyFile := routing.PrivateKeyFile(name)
+		f, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			log.Fatalf("could not create private key: %v", err)
+		}
+		_, err = fmt.Fprintln(f, pubsub.EncodePrivateKey(private))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			log.Fatalf("could not write %s: %v", keyFile, err)
+		}
+		keys[name] = public
+		fmt.Printf("Wrote %s\n", keyFile)
+	}
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeKeys(path, append(data, '\n')); err != nil {
+		log.Fatalf("could not write %s: %v", path, err)
+	}
+	fmt.Printf("Added %d public key(s) to %s\n", len(os.Args)-1, path)
+}
+
+// writeKeys replaces the keys file in one step, so running servers and
+// clients that reload it never read it half-written.
+func writeKeys(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// maxShownBody keeps "dlq show" readable for large payloads.
+const maxShownBody = 512
+
+func handleDLQCommand(dlq *pubsub.DeadLetterQueue, words []string) {
+	if len(words) < 2 {
+		fmt.Println("usage: dlq <list|show|replay|purge>")
+		return
+	}
+	switch words[1] {
+	case "list":
+		letters, err := dlq.List()
+		if err != nil {
+			fmt.Printf("could not list dead letters: %v\n", err)
+			return
+		}
+		if len(letters) == 0 {
+			fmt.Println("The dead-letter queue is empty.")
+			return
+		}
+		for i, dl := range letters {
+			fmt.Printf("%d. %s %s: %s from %s (%d death(s), %s, %d bytes)\n",
+				i+1, dl.OriginalExchange, dl.OriginalRoutingKey, dl.Reason, dl.Queue, dl.Deaths, dl.ContentType, len(dl.Body))
+		}
+	case "show":
+		n, ok := parseDLQIndex(words)
+		if !ok {
+			fmt.Println("usage: dlq show <n>")
+			return
+		}
+		letters, err := dlq.List()
+		if err != nil {
+			fmt.Printf("could not list dead letters: %v\n", err)
+			return
+		}
+		if n > len(letters) {
+			fmt.Printf("there are only %d dead letters\n", len(letters))
+			return
+		}
+		printDeadLetter(letters[n-1])
+	case "replay":
+		if len(words) < 3 {
+			fmt.Println("usage: dlq replay <n|all>")
+			return
+		}
+		selected := func(int, pubsub.DeadLetter) bool { return true }
+		if words[2] != "all" {
+			n, ok := parseDLQIndex(words)
+			if !ok {
+				fmt.Println("usage: dlq replay <n|all>")
+				return
+			}
+			selected = func(i int, _ pubsub.DeadLetter) bool { return i == n }
+		}
+		replayed, err := dlq.Replay(selected)
+		if err != nil {
+			fmt.Printf("could not replay dead letters: %v\n", err)
+		}
+		fmt.Printf("Replayed %d message(s)\n", replayed)
+	case "purge":
+		purged, err := dlq.Purge()
+		if err != nil {
+			fmt.Printf("could not purge dead letters: %v\n", err)
+			return
+		}
+		fmt.Printf("Purged %d message(s)\n", purged)
+	default:
+		fmt.Println("usage: dlq <list|show|replay|purge>")
+	}
+}
+
+func parseDLQIndex(words []string) (int, bool) {
+	if len(words) < 3 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(words[2])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+func printDeadLetter(dl pubsub.DeadLetter) {
+	fmt.Printf("Original exchange:    %s\n", dl.OriginalExchange)
+	fmt.Printf("Original routing key: %s\n", dl.OriginalRoutingKey)
+	fmt.Printf("Dead-lettered from:   %s\n", dl.Queue)
+	fmt.Printf("Reason:               %s\n", dl.Reason)
+	fmt.Printf("Deaths:               %d\n", dl.Deaths)
+	fmt.Printf("Message type:         %s\n", dl.Type)
+	fmt.Printf("Message ID:           %s\n", dl.MessageID)
+	fmt.Printf("Producer:             %s\n", dl.Producer)
+	fmt.Printf("Published:            %s\n", dl.Timestamp.Format(time.RFC3339))
+	fmt.Printf("Content type:         %s\n", dl.ContentType)
+	fmt.Printf("Content encoding:     %s\n", dl.ContentEncoding)
+	fmt.Println("Headers:")
+	keys := make([]string, 0, len(dl.Headers))
+	for k := range dl.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("  %s: %v\n", k, dl.Headers[k])
+	}
+	// Show compressed bodies decompressed if possible, and as stored if not.
+	msg, err := pubsub.Decompress(dl.Message)
+	if err != nil {
+		fmt.Println(err)
+		msg = dl.Message
+	}
+	body := msg.Body
+	truncated := len(body) > maxShownBody
+	if truncated {
+		body = body[:maxShownBody]
+	}
+	fmt.Println("Body:")
+	if dl.ContentType == "application/json" {
+		fmt.Println(string(body))
+	} else {
+		fmt.Print(hex.Dump(body))
+	}
+	if truncated {
+		fmt.Printf("... %d more bytes\n", len(msg.Body)-maxShownBody)
+	}
+}
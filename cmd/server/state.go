@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// playingState is the authoritative pause state, handed to clients that
+// join after the last pause or resume. It is saved to a file so a restarted
+// server still knows the game is paused, and kept in step with the other
+// servers through the pause broadcasts.
+type playingState struct {
+	mu    sync.Mutex
+	path  string
+	state routing.PlayingState
+}
+
+func loadPlayingState(path string) (*playingState, error) {
+	s := &playingState{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		// Numbering from the clock keeps it ahead of the states clients saw
+		// before the file was lost.
+		s.state.Seq = uint64(time.Now().UnixNano())
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// set records a pause or resume made on this server and returns the state
+// to broadcast.
+func (s *playingState) set(paused bool) (routing.PlayingState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = routing.PlayingState{IsPaused: paused, Seq: s.state.Seq + 1}
+	return s.state, s.save()
+}
+
+// observe records a state broadcast by any server, including this one, if
+// it is newer than the one held.
+func (s *playingState) observe(ps routing.PlayingState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ps.Seq <= s.state.Seq {
+		return nil
+	}
+	s.state = ps
+	return s.save()
+}
+
+func (s *playingState) save() error {
+	data, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// handleRequest answers a client's PlayingStateRequest.
+func (s *playingState) handleRequest(routing.PlayingStateRequest) (routing.PlayingState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestPlayingStateSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "playing_state.json")
+	s, err := loadPlayingState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paused, err := s.set(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = loadPlayingState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.handleRequest(routing.PlayingStateRequest{}); got != paused {
+		t.Errorf("restarted with state %+v, want %+v", got, paused)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("left %d files behind, want only the state file", len(entries))
+	}
+}
+
+func TestPlayingStateWithoutFileStaysAhead(t *testing.T) {
+	// A client saw this state before the server lost its file.
+	seen := routing.PlayingState{IsPaused: true, Seq: uint64(time.Now().UnixNano())}
+	s, err := loadPlayingState(filepath.Join(t.TempDir(), "playing_state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := s.set(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Seq <= seen.Seq {
+		t.Errorf("resumed with seq %d, which clients that saw seq %d would ignore", resumed.Seq, seen.Seq)
+	}
+}
@@ -1,5 +1,12 @@
 module github.com/bootdotdev/learn-pub-sub-starter
 
-go 1.22.1
+go 1.23
 
-require github.com/rabbitmq/amqp091-go v1.10.0 // indirect
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/rabbitmq/amqp091-go v1.10.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.12
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -1,5 +1,20 @@
 package gamelogic
 
+import "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+
+// Schema versions of the message types. Bump one when its struct, or a
+// struct it embeds, changes and register an upgrader from the previous
+// version.
+const (
+	ArmyMoveVersion         = 1
+	RecognitionOfWarVersion = 1
+)
+
+func init() {
+	pubsub.RegisterType[ArmyMove]("peril.ArmyMove", ArmyMoveVersion)
+	pubsub.RegisterType[RecognitionOfWar]("peril.RecognitionOfWar", RecognitionOfWarVersion)
+}
+
 type Player struct {
 	Username string
 	Units    map[int]Unit
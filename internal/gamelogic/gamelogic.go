@@ -2,11 +2,14 @@ package gamelogic
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
 	"os"
 	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
 )
 
 func PrintClientHelp() {
@@ -42,10 +45,22 @@ func PrintServerHelp() {
 	fmt.Println("Possible commands:")
 	fmt.Println("* pause")
 	fmt.Println("* resume")
+	fmt.Println("* dlq list")
+	fmt.Println("* dlq show <n>")
+	fmt.Println("* dlq replay <n|all>")
+	fmt.Println("* dlq purge")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
 
+// Reprompt prints the prompt again after a handler has written over it.
+func Reprompt(next pubsub.Handler) pubsub.Handler {
+	return func(ctx context.Context, d pubsub.Delivery, val any) (pubsub.AckType, error) {
+		defer fmt.Print("> ")
+		return next(ctx, d, val)
+	}
+}
+
 func GetInput() []string {
 	fmt.Print("> ")
 	scanner := bufio.NewScanner(os.Stdin)
@@ -5,9 +5,10 @@ import (
 )
 
 type GameState struct {
-	Player Player
-	Paused bool
-	mu     *sync.RWMutex
+	Player   Player
+	Paused   bool
+	pauseSeq uint64
+	mu       *sync.RWMutex
 }
 
 func NewGameState(username string) *GameState {
@@ -6,7 +6,12 @@ import (
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
+// HandlePause applies ps unless a newer pause state has already been
+// applied.
 func (gs *GameState) HandlePause(ps routing.PlayingState) {
+	if !gs.newerPause(ps.Seq) {
+		return
+	}
 	defer fmt.Println("------------------------")
 	fmt.Println()
 	if ps.IsPaused {
@@ -17,3 +22,28 @@ func (gs *GameState) HandlePause(ps routing.PlayingState) {
 		gs.resumeGame()
 	}
 }
+
+// SyncPause applies the pause state fetched on joining. Only a pause is
+// announced, as a running game is what a new player expects.
+func (gs *GameState) SyncPause(ps routing.PlayingState) {
+	if ps.IsPaused {
+		gs.HandlePause(ps)
+		return
+	}
+	gs.newerPause(ps.Seq)
+}
+
+// newerPause records seq and reports whether it is newer than the pause
+// state already applied. States without a seq are always applied.
+func (gs *GameState) newerPause(seq uint64) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if seq == 0 {
+		return true
+	}
+	if seq <= gs.pauseSeq {
+		return false
+	}
+	gs.pauseSeq = seq
+	return true
+}
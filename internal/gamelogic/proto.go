@@ -0,0 +1,94 @@
+package gamelogic
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/perilpb"
+	"google.golang.org/protobuf/proto"
+)
+
+func (mv ArmyMove) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&perilpb.ArmyMove{
+		Player:     playerToProto(mv.Player),
+		Units:      unitsToProto(mv.Units),
+		ToLocation: string(mv.ToLocation),
+	})
+}
+
+func (mv *ArmyMove) UnmarshalProto(data []byte) error {
+	var pb perilpb.ArmyMove
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	*mv = ArmyMove{
+		Player:     playerFromProto(pb.GetPlayer()),
+		Units:      unitsFromProto(pb.GetUnits()),
+		ToLocation: Location(pb.GetToLocation()),
+	}
+	return nil
+}
+
+func (rw RecognitionOfWar) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&perilpb.RecognitionOfWar{
+		Attacker: playerToProto(rw.Attacker),
+		Defender: playerToProto(rw.Defender),
+	})
+}
+
+func (rw *RecognitionOfWar) UnmarshalProto(data []byte) error {
+	var pb perilpb.RecognitionOfWar
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	*rw = RecognitionOfWar{
+		Attacker: playerFromProto(pb.GetAttacker()),
+		Defender: playerFromProto(pb.GetDefender()),
+	}
+	return nil
+}
+
+func playerToProto(p Player) *perilpb.Player {
+	units := make(map[int64]*perilpb.Unit, len(p.Units))
+	for id, u := range p.Units {
+		units[int64(id)] = unitToProto(u)
+	}
+	return &perilpb.Player{Username: p.Username, Units: units}
+}
+
+func playerFromProto(pb *perilpb.Player) Player {
+	units := make(map[int]Unit, len(pb.GetUnits()))
+	for id, u := range pb.GetUnits() {
+		units[int(id)] = unitFromProto(u)
+	}
+	return Player{Username: pb.GetUsername(), Units: units}
+}
+
+func unitsToProto(units []Unit) []*perilpb.Unit {
+	pbs := make([]*perilpb.Unit, len(units))
+	for i, u := range units {
+		pbs[i] = unitToProto(u)
+	}
+	return pbs
+}
+
+func unitsFromProto(pbs []*perilpb.Unit) []Unit {
+	units := make([]Unit, len(pbs))
+	for i, pb := range pbs {
+		units[i] = unitFromProto(pb)
+	}
+	return units
+}
+
+func unitToProto(u Unit) *perilpb.Unit {
+	return &perilpb.Unit{
+		Id:       int64(u.ID),
+		Rank:     string(u.Rank),
+		Location: string(u.Location),
+	}
+}
+
+func unitFromProto(pb *perilpb.Unit) Unit {
+	return Unit{
+		ID:       int(pb.GetId()),
+		Rank:     UnitRank(pb.GetRank()),
+		Location: Location(pb.GetLocation()),
+	}
+}
@@ -0,0 +1,53 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+var testPlayer = Player{
+	Username: "alice",
+	Units: map[int]Unit{
+		1: {ID: 1, Rank: RankArtillery, Location: "europe"},
+		2: {ID: 2, Rank: RankInfantry, Location: "asia"},
+	},
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	move := ArmyMove{
+		Player:     testPlayer,
+		Units:      []Unit{testPlayer.Units[1]},
+		ToLocation: "europe",
+	}
+	war := RecognitionOfWar{
+		Attacker: testPlayer,
+		Defender: Player{Username: "bob", Units: map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}}},
+	}
+	for _, codec := range []pubsub.Codec{pubsub.MsgPack, pubsub.Protobuf} {
+		data, err := codec.Marshal(move)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		var gotMove ArmyMove
+		if err := codec.Unmarshal(data, &gotMove); err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		if !reflect.DeepEqual(gotMove, move) {
+			t.Errorf("%s: got %+v, want %+v", codec.ContentType(), gotMove, move)
+		}
+
+		data, err = codec.Marshal(war)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		var gotWar RecognitionOfWar
+		if err := codec.Unmarshal(data, &gotWar); err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		if !reflect.DeepEqual(gotWar, war) {
+			t.Errorf("%s: got %+v, want %+v", codec.ContentType(), gotWar, war)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+// Package perilpb holds the protocol buffer definitions of the game messages.
+package perilpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative peril.proto
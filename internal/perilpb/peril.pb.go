@@ -0,0 +1,457 @@
+// Wire format for Peril game messages, for tooling that cannot read gob.
+// The Go types in internal/gamelogic and internal/routing convert to and
+// from these messages; regenerate peril.pb.go with `go generate`.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        v5.29.3
+// source: peril.proto
+
+package perilpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Unit struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Rank          string                 `protobuf:"bytes,2,opt,name=rank,proto3" json:"rank,omitempty"`
+	Location      string                 `protobuf:"bytes,3,opt,name=location,proto3" json:"location,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Unit) Reset() {
+	*x = Unit{}
+	mi := &file_peril_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Unit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Unit) ProtoMessage() {}
+
+func (x *Unit) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Unit.ProtoReflect.Descriptor instead.
+func (*Unit) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Unit) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Unit) GetRank() string {
+	if x != nil {
+		return x.Rank
+	}
+	return ""
+}
+
+func (x *Unit) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
+type Player struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Username      string                 `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Units         map[int64]*Unit        `protobuf:"bytes,2,rep,name=units,proto3" json:"units,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Player) Reset() {
+	*x = Player{}
+	mi := &file_peril_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Player) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Player) ProtoMessage() {}
+
+func (x *Player) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Player.ProtoReflect.Descriptor instead.
+func (*Player) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Player) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *Player) GetUnits() map[int64]*Unit {
+	if x != nil {
+		return x.Units
+	}
+	return nil
+}
+
+type ArmyMove struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Player        *Player                `protobuf:"bytes,1,opt,name=player,proto3" json:"player,omitempty"`
+	Units         []*Unit                `protobuf:"bytes,2,rep,name=units,proto3" json:"units,omitempty"`
+	ToLocation    string                 `protobuf:"bytes,3,opt,name=to_location,json=toLocation,proto3" json:"to_location,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ArmyMove) Reset() {
+	*x = ArmyMove{}
+	mi := &file_peril_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ArmyMove) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ArmyMove) ProtoMessage() {}
+
+func (x *ArmyMove) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ArmyMove.ProtoReflect.Descriptor instead.
+func (*ArmyMove) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ArmyMove) GetPlayer() *Player {
+	if x != nil {
+		return x.Player
+	}
+	return nil
+}
+
+func (x *ArmyMove) GetUnits() []*Unit {
+	if x != nil {
+		return x.Units
+	}
+	return nil
+}
+
+func (x *ArmyMove) GetToLocation() string {
+	if x != nil {
+		return x.ToLocation
+	}
+	return ""
+}
+
+type RecognitionOfWar struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Attacker      *Player                `protobuf:"bytes,1,opt,name=attacker,proto3" json:"attacker,omitempty"`
+	Defender      *Player                `protobuf:"bytes,2,opt,name=defender,proto3" json:"defender,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecognitionOfWar) Reset() {
+	*x = RecognitionOfWar{}
+	mi := &file_peril_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecognitionOfWar) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecognitionOfWar) ProtoMessage() {}
+
+func (x *RecognitionOfWar) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecognitionOfWar.ProtoReflect.Descriptor instead.
+func (*RecognitionOfWar) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RecognitionOfWar) GetAttacker() *Player {
+	if x != nil {
+		return x.Attacker
+	}
+	return nil
+}
+
+func (x *RecognitionOfWar) GetDefender() *Player {
+	if x != nil {
+		return x.Defender
+	}
+	return nil
+}
+
+type PlayingState struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	IsPaused      bool                   `protobuf:"varint,1,opt,name=is_paused,json=isPaused,proto3" json:"is_paused,omitempty"`
+	Seq           uint64                 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PlayingState) Reset() {
+	*x = PlayingState{}
+	mi := &file_peril_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PlayingState) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PlayingState) ProtoMessage() {}
+
+func (x *PlayingState) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PlayingState.ProtoReflect.Descriptor instead.
+func (*PlayingState) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PlayingState) GetIsPaused() bool {
+	if x != nil {
+		return x.IsPaused
+	}
+	return false
+}
+
+func (x *PlayingState) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+type GameLog struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CurrentTime   *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=current_time,json=currentTime,proto3" json:"current_time,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Username      string                 `protobuf:"bytes,3,opt,name=username,proto3" json:"username,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GameLog) Reset() {
+	*x = GameLog{}
+	mi := &file_peril_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GameLog) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GameLog) ProtoMessage() {}
+
+func (x *GameLog) ProtoReflect() protoreflect.Message {
+	mi := &file_peril_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GameLog.ProtoReflect.Descriptor instead.
+func (*GameLog) Descriptor() ([]byte, []int) {
+	return file_peril_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GameLog) GetCurrentTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CurrentTime
+	}
+	return nil
+}
+
+func (x *GameLog) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *GameLog) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+var File_peril_proto protoreflect.FileDescriptor
+
+const file_peril_proto_rawDesc = "" +
+	"\n" +
+	"\vperil.proto\x12\x05peril\x1a\x1fgoogle/protobuf/timestamp.proto\"F\n" +
+	"\x04Unit\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04rank\x18\x02 \x01(\tR\x04rank\x12\x1a\n" +
+	"\blocation\x18\x03 \x01(\tR\blocation\"\x9b\x01\n" +
+	"\x06Player\x12\x1a\n" +
+	"\busername\x18\x01 \x01(\tR\busername\x12.\n" +
+	"\x05units\x18\x02 \x03(\v2\x18.peril.Player.UnitsEntryR\x05units\x1aE\n" +
+	"\n" +
+	"UnitsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\x03R\x03key\x12!\n" +
+	"\x05value\x18\x02 \x01(\v2\v.peril.UnitR\x05value:\x028\x01\"u\n" +
+	"\bArmyMove\x12%\n" +
+	"\x06player\x18\x01 \x01(\v2\r.peril.PlayerR\x06player\x12!\n" +
+	"\x05units\x18\x02 \x03(\v2\v.peril.UnitR\x05units\x12\x1f\n" +
+	"\vto_location\x18\x03 \x01(\tR\n" +
+	"toLocation\"h\n" +
+	"\x10RecognitionOfWar\x12)\n" +
+	"\battacker\x18\x01 \x01(\v2\r.peril.PlayerR\battacker\x12)\n" +
+	"\bdefender\x18\x02 \x01(\v2\r.peril.PlayerR\bdefender\"=\n" +
+	"\fPlayingState\x12\x1b\n" +
+	"\tis_paused\x18\x01 \x01(\bR\bisPaused\x12\x10\n" +
+	"\x03seq\x18\x02 \x01(\x04R\x03seq\"~\n" +
+	"\aGameLog\x12=\n" +
+	"\fcurrent_time\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\vcurrentTime\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x1a\n" +
+	"\busername\x18\x03 \x01(\tR\busernameB>Z<github.com/bootdotdev/learn-pub-sub-starter/internal/perilpbb\x06proto3"
+
+var (
+	file_peril_proto_rawDescOnce sync.Once
+	file_peril_proto_rawDescData []byte
+)
+
+func file_peril_proto_rawDescGZIP() []byte {
+	file_peril_proto_rawDescOnce.Do(func() {
+		file_peril_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_peril_proto_rawDesc), len(file_peril_proto_rawDesc)))
+	})
+	return file_peril_proto_rawDescData
+}
+
+var file_peril_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_peril_proto_goTypes = []any{
+	(*Unit)(nil),                  // 0: peril.Unit
+	(*Player)(nil),                // 1: peril.Player
+	(*ArmyMove)(nil),              // 2: peril.ArmyMove
+	(*RecognitionOfWar)(nil),      // 3: peril.RecognitionOfWar
+	(*PlayingState)(nil),          // 4: peril.PlayingState
+	(*GameLog)(nil),               // 5: peril.GameLog
+	nil,                           // 6: peril.Player.UnitsEntry
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_peril_proto_depIdxs = []int32{
+	6, // 0: peril.Player.units:type_name -> peril.Player.UnitsEntry
+	1, // 1: peril.ArmyMove.player:type_name -> peril.Player
+	0, // 2: peril.ArmyMove.units:type_name -> peril.Unit
+	1, // 3: peril.RecognitionOfWar.attacker:type_name -> peril.Player
+	1, // 4: peril.RecognitionOfWar.defender:type_name -> peril.Player
+	7, // 5: peril.GameLog.current_time:type_name -> google.protobuf.Timestamp
+	0, // 6: peril.Player.UnitsEntry.value:type_name -> peril.Unit
+	7, // [7:7] is the sub-list for method output_type
+	7, // [7:7] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_peril_proto_init() }
+func file_peril_proto_init() {
+	if File_peril_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_peril_proto_rawDesc), len(file_peril_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_peril_proto_goTypes,
+		DependencyIndexes: file_peril_proto_depIdxs,
+		MessageInfos:      file_peril_proto_msgTypes,
+	}.Build()
+	File_peril_proto = out.File
+	file_peril_proto_goTypes = nil
+	file_peril_proto_depIdxs = nil
+}
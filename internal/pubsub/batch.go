@@ -0,0 +1,63 @@
+package pubsub
+
+import "fmt"
+
+// BatchMessage is one message of a batch given to Broker.PublishBatch.
+type BatchMessage struct {
+	Exchange string
+	Key      string
+	Message  Message
+}
+
+// NewBatchMessage encodes val the way Publish would, for publishing as part
+// of a batch. WithConfirm has no effect, as batches are always confirmed.
+func NewBatchMessage[T any](exchange, key string, val T, codec Codec, opts ...PublishOption) (BatchMessage, error) {
+	body, err := codec.Marshal(val)
+	if err != nil {
+		return BatchMessage{}, err
+	}
+	msg := Message{
+		ContentType: codec.ContentType(),
+		Body:        body,
+	}
+	envelope[T](&msg)
+	o := newPublishOptions(opts)
+	// The publish span covers encoding only, as the batch is published later.
+	ctx, span := startPublishSpan(o.ctx, exchange, key, msg)
+	msg, err = prepare(ctx, key, msg, o)
+	span.end(err)
+	if err != nil {
+		return BatchMessage{}, err
+	}
+	return BatchMessage{Exchange: exchange, Key: key, Message: msg}, nil
+}
+
+// BatchError reports the messages of a batch that were not confirmed.
+// Errors is indexed like the batch, with nil for every confirmed message.
+type BatchError struct {
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	var first error
+	for _, err := range e.Errors {
+		if err != nil {
+			failed++
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return fmt.Sprintf("%d of %d messages failed, first: %v", failed, len(e.Errors), first)
+}
+
+// batchResult returns a *BatchError if any of errs is set.
+func batchResult(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errors: errs}
+		}
+	}
+	return nil
+}
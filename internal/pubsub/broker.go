@@ -0,0 +1,156 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Broker is the transport the publish and subscribe helpers run on. The
+// RabbitMQ backend is returned by DialRabbit.
+type Broker interface {
+	Publish(exchange, key string, msg Message) error
+	// PublishConfirm publishes msg as mandatory and waits until the broker
+	// has taken responsibility for it. Unroutable messages are reported as a
+	// *ReturnError.
+	PublishConfirm(ctx context.Context, exchange, key string, msg Message) error
+	// PublishBatch publishes every message like PublishConfirm, but sends
+	// them all before waiting for any confirm. Failures are reported as a
+	// *BatchError.
+	PublishBatch(ctx context.Context, batch []BatchMessage) error
+	DeclareExchange(name, kind string) error
+	DeclareAndBind(exchange, queueName, key string, simpleQueueType SimpleQueueType) error
+	// DeclareQueue declares a queue without binding it to any exchange.
+	// Messages reach it through the default exchange or by dead-lettering.
+	DeclareQueue(queueName string, opts QueueOptions) error
+	BindQueue(queueName, key, exchange string) error
+	// Get takes the next message off queueName without a consumer. It
+	// reports false if the queue is empty. The delivery must be settled.
+	Get(queueName string) (Delivery, bool, error)
+	PurgeQueue(queueName string) (int, error)
+	// Consume delivers messages from queueName, with at most prefetch of
+	// them unacknowledged, until ctx is done and then closes the returned
+	// channel. Deliveries already received can still be settled after that.
+	Consume(ctx context.Context, queueName string, prefetch int) (<-chan Delivery, error)
+	Close() error
+}
+
+type QueueOptions struct {
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	// DeadLetter turns on dead-lettering to DeadLetterExchange, which may be
+	// the default exchange "". An empty DeadLetterRoutingKey keeps the
+	// message's own routing key.
+	DeadLetter           bool
+	DeadLetterExchange   string
+	DeadLetterRoutingKey string
+	MessageTTL           time.Duration
+	// Expires deletes the queue once it has gone unused, with no consumers
+	// and not redeclared, for this long.
+	Expires time.Duration
+}
+
+// queueOptions returns the options DeclareAndBind uses for simpleQueueType.
+func queueOptions(simpleQueueType SimpleQueueType) QueueOptions {
+	return QueueOptions{
+		Durable:            simpleQueueType == Durable,
+		AutoDelete:         simpleQueueType == Transient,
+		Exclusive:          simpleQueueType == Transient,
+		DeadLetter:         true,
+		DeadLetterExchange: DefaultDeadLetterExchange,
+	}
+}
+
+// Message is an AMQP message. Type, MessageID, Timestamp and Producer form
+// its envelope and travel in the AMQP type, message-id, timestamp and app-id
+// properties; the schema version of Body is in the x-schema-version header.
+type Message struct {
+	ContentType string
+	// ContentEncoding names the compression applied to Body, if any.
+	ContentEncoding string
+	Headers         map[string]any
+	Body            []byte
+	Type            string
+	MessageID       string
+	Timestamp       time.Time
+	Producer        string
+	// ReplyTo and CorrelationID route the reply to a request made by Call.
+	// CorrelationID is the AMQP property, unrelated to the x-correlation-id
+	// header that holds Lineage.ChainID.
+	ReplyTo       string
+	CorrelationID string
+	// Expiration drops the message, or dead-letters it, if it has not been
+	// consumed in time. Zero keeps it until it is.
+	Expiration time.Duration
+}
+
+type Acknowledger interface {
+	Ack() error
+	Nack(requeue bool) error
+}
+
+type Delivery struct {
+	Message
+	Exchange     string
+	RoutingKey   string
+	Redelivered  bool
+	Acknowledger Acknowledger
+}
+
+func copyHeaders(headers map[string]any) map[string]any {
+	if headers == nil {
+		return nil
+	}
+	copied := make(map[string]any, len(headers))
+	for k, v := range headers {
+		if deaths, ok := v.([]any); ok {
+			v = append([]any(nil), deaths...)
+		}
+		copied[k] = v
+	}
+	return copied
+}
+
+// withHeaders returns msg with the headers in set added, in a copy of its
+// header map so the caller's message is left as it was.
+func withHeaders(msg Message, set map[string]any) Message {
+	headers := copyHeaders(msg.Headers)
+	if headers == nil {
+		headers = make(map[string]any, len(set))
+	}
+	for k, v := range set {
+		headers[k] = v
+	}
+	msg.Headers = headers
+	return msg
+}
+
+// withHeader is withHeaders for a single header.
+func withHeader(msg Message, k string, v any) Message {
+	return withHeaders(msg, map[string]any{k: v})
+}
+
+func (d Delivery) Ack() error {
+	return d.Acknowledger.Ack()
+}
+
+func (d Delivery) Nack(requeue bool) error {
+	return d.Acknowledger.Nack(requeue)
+}
+
+var ErrNacked = errors.New("message was nacked by broker")
+
+// ReturnError reports a mandatory publish the broker could not route to any
+// queue.
+type ReturnError struct {
+	Exchange  string
+	Key       string
+	ReplyCode int
+	ReplyText string
+}
+
+func (e *ReturnError) Error() string {
+	return fmt.Sprintf("message to %s with key %s was returned: %d %s", e.Exchange, e.Key, e.ReplyCode, e.ReplyText)
+}
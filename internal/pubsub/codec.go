@@ -0,0 +1,86 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"sync"
+)
+
+// Codec turns values into message bodies and back for one content type.
+type Codec interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON Codec = jsonCodec{}
+	Gob  Codec = gobCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		JSON.ContentType():     JSON,
+		Gob.ContentType():      Gob,
+		MsgPack.ContentType():  MsgPack,
+		Protobuf.ContentType(): Protobuf,
+	}
+)
+
+// RegisterCodec makes c available to Subscribe for deliveries with its
+// content type, replacing any codec already registered for it.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+// CodecFor looks up the codec registered for contentType. Parameters such as
+// "; charset=utf-8" are ignored.
+func CodecFor(contentType string) (Codec, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("no codec registered for content type %q", mediaType)
+	}
+	return c, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string {
+	return "application/gob"
+}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
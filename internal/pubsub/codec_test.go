@@ -0,0 +1,85 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        Codec
+	}{
+		{"application/json", JSON},
+		{"application/json; charset=utf-8", JSON},
+		{"application/gob", Gob},
+		{"application/xml", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := CodecFor(tt.contentType)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("CodecFor(%q) = %v, want an error", tt.contentType, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("CodecFor(%q) = %v, %v, want %v", tt.contentType, got, err, tt.want)
+		}
+	}
+}
+
+func TestSubscribeDecodesByContentType(t *testing.T) {
+	b := newTestBroker(t)
+	got := make(chan testMove, 3)
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		got <- mv
+		return Ack, nil
+	})
+
+	if err := Publish(b, testExchange, "army_moves.alice", testMove{Player: "alice", Seq: 1}, JSON); err != nil {
+		t.Fatal(err)
+	}
+	if err := Publish(b, testExchange, "army_moves.alice", testMove{Player: "alice", Seq: 2}, Gob); err != nil {
+		t.Fatal(err)
+	}
+	// Without a content type the subscription's default codec applies.
+	raw := Message{Body: []byte(`{"Player":"alice","Seq":3}`)}
+	if err := b.Publish(testExchange, "army_moves.alice", raw); err != nil {
+		t.Fatal(err)
+	}
+	for seq := 1; seq <= 3; seq++ {
+		select {
+		case mv := <-got:
+			if mv != (testMove{Player: "alice", Seq: seq}) {
+				t.Errorf("got %+v, want move %d of alice", mv, seq)
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("move %d was not handled", seq)
+		}
+	}
+}
+
+func TestSubscribeDeadLettersUndecodable(t *testing.T) {
+	b := newTestBroker(t)
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		t.Error("handler called with an undecodable message")
+		return Ack, nil
+	})
+
+	for _, msg := range []Message{
+		{ContentType: JSON.ContentType(), Body: []byte("{")},
+		{ContentType: "application/xml", Body: []byte("<move/>")},
+	} {
+		if err := b.Publish(testExchange, "army_moves.alice", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dl := range deadLetters(t, b, 2) {
+		if dl.Reason != "rejected" {
+			t.Errorf("got reason %q, want rejected", dl.Reason)
+		}
+	}
+}
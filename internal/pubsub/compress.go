@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressionThreshold is the body size below which WithCompression
+// leaves messages uncompressed, as compressing them saves little or nothing.
+const DefaultCompressionThreshold = 1024
+
+// MaxDecompressedSize caps the size a body may decompress to, so a small
+// compressed payload cannot exhaust the memory of every consumer.
+const MaxDecompressedSize = 16 << 20
+
+var ErrTooLarge = fmt.Errorf("decompressed body is larger than %d bytes", MaxDecompressedSize)
+
+// Compressor compresses message bodies for one content encoding. Decompress
+// must fail with ErrTooLarge rather than return more than
+// MaxDecompressedSize bytes.
+type Compressor interface {
+	Encoding() string
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+var (
+	Gzip Compressor = gzipCompressor{}
+	Zstd Compressor = newZstdCompressor()
+)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[string]Compressor{
+		Gzip.Encoding(): Gzip,
+		Zstd.Encoding(): Zstd,
+	}
+)
+
+// RegisterCompressor makes c available for decompressing deliveries with
+// its content encoding, replacing any compressor already registered for it.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Encoding()] = c
+}
+
+// WithCompression compresses bodies of at least threshold bytes with c.
+func WithCompression(c Compressor, threshold int) PublishOption {
+	return func(o *publishOptions) {
+		o.compressor = c
+		o.compressionThreshold = threshold
+	}
+}
+
+func compress(msg Message, c Compressor, threshold int) (Message, error) {
+	if c == nil || msg.ContentEncoding != "" || len(msg.Body) < threshold {
+		return msg, nil
+	}
+	body, err := c.Compress(msg.Body)
+	if err != nil {
+		return msg, fmt.Errorf("could not compress message: %w", err)
+	}
+	msg.Body = body
+	msg.ContentEncoding = c.Encoding()
+	return msg, nil
+}
+
+// Decompress returns msg with its body decompressed according to its
+// content encoding.
+func Decompress(msg Message) (Message, error) {
+	if msg.ContentEncoding == "" {
+		return msg, nil
+	}
+	compressorsMu.RLock()
+	c, ok := compressors[msg.ContentEncoding]
+	compressorsMu.RUnlock()
+	if !ok {
+		return msg, fmt.Errorf("no compressor registered for content encoding %q", msg.ContentEncoding)
+	}
+	body, err := c.Decompress(msg.Body)
+	if err != nil {
+		return msg, fmt.Errorf("could not decompress message: %w", err)
+	}
+	msg.Body = body
+	msg.ContentEncoding = ""
+	return msg, nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Encoding() string {
+	return "gzip"
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err = io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+// zstdCompressor shares one encoder and decoder, which are safe for
+// concurrent use through EncodeAll and DecodeAll.
+type zstdCompressor struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+func newZstdCompressor() zstdCompressor {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		panic(err)
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+	if err != nil {
+		panic(err)
+	}
+	return zstdCompressor{enc: enc, dec: dec}
+}
+
+func (zstdCompressor) Encoding() string {
+	return "zstd"
+}
+
+func (c zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return c.enc.EncodeAll(data, nil), nil
+}
+
+func (c zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	data, err := c.dec.DecodeAll(data, nil)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return nil, ErrTooLarge
+	}
+	return data, err
+}
@@ -0,0 +1,61 @@
+package pubsub
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	body := bytes.Repeat([]byte("alice moves to europe "), 100)
+	for _, c := range []Compressor{Gzip, Zstd} {
+		msg, err := compress(Message{Body: body}, c, DefaultCompressionThreshold)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Encoding(), err)
+		}
+		if msg.ContentEncoding != c.Encoding() || len(msg.Body) >= len(body) {
+			t.Errorf("%s: got encoding %q and %d bytes from %d", c.Encoding(), msg.ContentEncoding, len(msg.Body), len(body))
+		}
+		got, err := Decompress(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Encoding(), err)
+		}
+		if !bytes.Equal(got.Body, body) || got.ContentEncoding != "" {
+			t.Errorf("%s: body did not survive compression", c.Encoding())
+		}
+	}
+}
+
+func TestCompressSkipsSmallBodies(t *testing.T) {
+	msg, err := compress(Message{Body: []byte("short")}, Zstd, DefaultCompressionThreshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentEncoding != "" {
+		t.Errorf("compressed a body below the threshold with %q", msg.ContentEncoding)
+	}
+}
+
+func TestDecompressCapsSize(t *testing.T) {
+	for _, c := range []Compressor{Gzip, Zstd} {
+		for size, want := range map[int]error{
+			MaxDecompressedSize:     nil,
+			MaxDecompressedSize + 1: ErrTooLarge,
+		} {
+			compressed, err := c.Compress(make([]byte, size))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = Decompress(Message{ContentEncoding: c.Encoding(), Body: compressed})
+			if !errors.Is(err, want) {
+				t.Errorf("%s: decompressing %d bytes: got %v, want %v", c.Encoding(), size, err, want)
+			}
+		}
+	}
+}
+
+func TestDecompressUnknownEncoding(t *testing.T) {
+	if _, err := Decompress(Message{ContentEncoding: "br", Body: []byte{1}}); err == nil {
+		t.Error("decompressed an unknown encoding")
+	}
+}
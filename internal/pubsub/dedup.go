@@ -0,0 +1,229 @@
+package pubsub
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deduplicator remembers the IDs of messages that are being or have been
+// handled.
+type Deduplicator interface {
+	// Claim reserves id for the handler about to run and reports whether it
+	// was free, in one step so two copies of a message handled at once
+	// cannot both claim it.
+	Claim(id string) (bool, error)
+	// Commit records that the message with a claimed id was handled.
+	Commit(id string) error
+	// Release gives up the claim on id so the message can be handled again.
+	Release(id string) error
+}
+
+// WithDedup acknowledges deliveries whose message ID d has already claimed
+// without handling them again. The ID is claimed before the handler runs,
+// committed if the handler acknowledges the delivery and released
+// otherwise, so a retried message is handled again; handlers with side
+// effects that a retry must not repeat have to be idempotent by message ID
+// themselves.
+func WithDedup(d Deduplicator) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.dedup = d
+	}
+}
+
+// Dedup is a Deduplicator that keeps the most recent size IDs seen within
+// window, optionally persisting committed ones to a file so they survive a
+// restart. Claims in flight are only held in memory, so a message whose
+// handler never finished is handled again after a crash.
+type Dedup struct {
+	mu      sync.Mutex
+	size    int
+	window  time.Duration
+	ids     map[string]*list.Element
+	order   *list.List
+	file    *os.File
+	path    string
+	written int
+}
+
+type dedupEntry struct {
+	id        string
+	seen      time.Time
+	committed bool
+}
+
+// NewDedup returns an in-memory Dedup. A window of 0 keeps IDs until they
+// are pushed out by newer ones.
+func NewDedup(size int, window time.Duration) *Dedup {
+	return &Dedup{
+		size:   size,
+		window: window,
+		ids:    map[string]*list.Element{},
+		order:  list.New(),
+	}
+}
+
+// OpenDedup returns a Dedup that loads the IDs recorded in path and appends
+// newly committed ones to it. The file is compacted as it grows. Only one
+// process may use path at a time.
+func OpenDedup(path string, size int, window time.Duration) (*Dedup, error) {
+	d := NewDedup(size, window)
+	d.path = path
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	if err := d.compact(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Dedup) Claim(id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.expire(now)
+	if _, ok := d.ids[id]; ok {
+		return false, nil
+	}
+	d.add(dedupEntry{id: id, seen: now})
+	return true, nil
+}
+
+func (d *Dedup) Commit(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry := dedupEntry{id: id, seen: time.Now(), committed: true}
+	if e, ok := d.ids[id]; ok {
+		entry.seen = e.Value.(dedupEntry).seen
+		e.Value = entry
+	} else {
+		d.add(entry)
+	}
+	return d.write(entry)
+}
+
+func (d *Dedup) Release(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.ids[id]; ok && !e.Value.(dedupEntry).committed {
+		d.remove(e)
+	}
+	return nil
+}
+
+func (d *Dedup) write(entry dedupEntry) error {
+	if d.file == nil {
+		return nil
+	}
+	if _, err := fmt.Fprintf(d.file, "%d %s\n", entry.seen.UnixNano(), entry.id); err != nil {
+		return err
+	}
+	d.written++
+	if d.written > 2*d.size {
+		return d.compact()
+	}
+	return nil
+}
+
+func (d *Dedup) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		return nil
+	}
+	return d.file.Close()
+}
+
+// add remembers entry as the newest ID, replacing any entry for the same
+// ID.
+func (d *Dedup) add(entry dedupEntry) {
+	if e, ok := d.ids[entry.id]; ok {
+		d.order.Remove(e)
+	}
+	d.ids[entry.id] = d.order.PushFront(entry)
+	for d.order.Len() > d.size {
+		d.remove(d.order.Back())
+	}
+	d.expire(time.Now())
+}
+
+// expire drops the IDs older than the window. The list runs from newest to
+// oldest, so they are all at the back.
+func (d *Dedup) expire(now time.Time) {
+	if d.window <= 0 {
+		return
+	}
+	for e := d.order.Back(); e != nil && now.Sub(e.Value.(dedupEntry).seen) > d.window; e = d.order.Back() {
+		d.remove(e)
+	}
+}
+
+func (d *Dedup) remove(e *list.Element) {
+	delete(d.ids, e.Value.(dedupEntry).id)
+	d.order.Remove(e)
+}
+
+func (d *Dedup) load() error {
+	f, err := os.Open(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		nanos, id, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(nanos, 10, 64)
+		if err != nil {
+			continue
+		}
+		d.add(dedupEntry{id: id, seen: time.Unix(0, n), committed: true})
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file with only the committed IDs still remembered.
+// The new file is written next to it under a unique name and renamed over
+// it.
+func (d *Dedup) compact() error {
+	f, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	w := bufio.NewWriter(f)
+	for e := d.order.Back(); e != nil; e = e.Prev() {
+		entry := e.Value.(dedupEntry)
+		if entry.committed {
+			fmt.Fprintf(w, "%d %s\n", entry.seen.UnixNano(), entry.id)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), d.path); err != nil {
+		return err
+	}
+	if d.file != nil {
+		d.file.Close()
+	}
+	d.file, err = os.OpenFile(d.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	d.written = 0
+	return err
+}
@@ -0,0 +1,224 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func mustClaim(t *testing.T, d Deduplicator, id string, want bool) {
+	t.Helper()
+	got, err := d.Claim(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Claim(%q) = %v, want %v", id, got, want)
+	}
+}
+
+func TestDedupClaimsOnce(t *testing.T) {
+	d := NewDedup(100, 0)
+	var claimed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := d.Claim("move-1"); ok {
+				claimed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := claimed.Load(); n != 1 {
+		t.Errorf("%d concurrent claims succeeded, want 1", n)
+	}
+}
+
+func TestDedupRelease(t *testing.T) {
+	d := NewDedup(100, 0)
+	mustClaim(t, d, "move-1", true)
+	if err := d.Release("move-1"); err != nil {
+		t.Fatal(err)
+	}
+	mustClaim(t, d, "move-1", true)
+	mustClaim(t, d, "move-1", false)
+}
+
+func TestDedupForgetsOldest(t *testing.T) {
+	d := NewDedup(2, 0)
+	for _, id := range []string{"1", "2", "3"} {
+		mustClaim(t, d, id, true)
+	}
+	mustClaim(t, d, "3", false)
+	mustClaim(t, d, "2", false)
+	mustClaim(t, d, "1", true)
+}
+
+func TestDedupWindow(t *testing.T) {
+	d := NewDedup(100, 20*time.Millisecond)
+	mustClaim(t, d, "1", true)
+	time.Sleep(30 * time.Millisecond)
+	mustClaim(t, d, "1", true)
+}
+
+func TestDedupPersistsCommitted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup")
+	d, err := OpenDedup(path, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More commits than twice the size make the file compact on the way.
+	for i := 0; i < 10; i++ {
+		mustClaim(t, d, fmt.Sprint(i), true)
+		if err := d.Commit(fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A claim whose handler never finished, as when the process crashes.
+	mustClaim(t, d, "in-flight", true)
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = OpenDedup(path, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	mustClaim(t, d, "9", false)
+	mustClaim(t, d, "8", false)
+	mustClaim(t, d, "in-flight", true)
+	mustClaim(t, d, "0", true)
+}
+
+func TestDedupKeepsCommitted(t *testing.T) {
+	d := NewDedup(100, 0)
+	mustClaim(t, d, "1", true)
+	if err := d.Commit("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Release("1"); err != nil {
+		t.Fatal(err)
+	}
+	mustClaim(t, d, "1", false)
+}
+
+func TestSubscribeSkipsDuplicates(t *testing.T) {
+	b := newTestBroker(t)
+	var mu sync.Mutex
+	handled := map[int]int{}
+	done := make(chan struct{})
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[mv.Seq]++
+		if mv.Seq == 3 {
+			close(done)
+		}
+		return Ack, nil
+	}, WithDedup(NewDedup(100, 0)))
+
+	// The first move is delivered twice, as after a lost acknowledgement.
+	for _, m := range []struct {
+		id  string
+		seq int
+	}{{"a", 1}, {"a", 1}, {"b", 2}, {"c", 3}} {
+		body, err := JSON.Marshal(testMove{Player: "alice", Seq: m.seq})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := Message{MessageID: m.id, ContentType: JSON.ContentType(), Body: body}
+		if err := b.Publish(testExchange, "army_moves.alice", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("moves were not handled")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for seq, n := range handled {
+		if n != 1 {
+			t.Errorf("move %d handled %d times, want once", seq, n)
+		}
+	}
+}
+
+func TestSubscribeHandlesRetriedDuplicate(t *testing.T) {
+	b := newTestBroker(t)
+	var attempts atomic.Int32
+	handled := make(chan struct{})
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		if attempts.Add(1) == 1 {
+			return NackRetry, errors.New("not yet")
+		}
+		close(handled)
+		return Ack, nil
+	}, WithDedup(NewDedup(100, 0)), WithRetry(testRetry))
+
+	if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(testTimeout):
+		t.Fatal("retry was skipped as a duplicate")
+	}
+}
+
+func TestSubscribeCommitsOnlyAcknowledged(t *testing.T) {
+	b := newTestBroker(t)
+	path := filepath.Join(t.TempDir(), "dedup")
+	d, err := OpenDedup(path, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := make(chan struct{})
+	handler := func(_ context.Context, mv testMove) (AckType, error) {
+		if mv.Seq == 1 {
+			return NackDiscard, nil
+		}
+		close(handled)
+		return Ack, nil
+	}
+	sub, err := SubscribeJSONErr(context.Background(), b, testExchange, "moves", "army_moves.*", Durable, handler, WithDedup(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for seq, id := range []string{"rejected", "acked"} {
+		body, err := JSON.Marshal(testMove{Player: "alice", Seq: seq + 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := Message{MessageID: id, ContentType: JSON.ContentType(), Body: body}
+		if err := b.Publish(testExchange, "army_moves.alice", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-handled:
+	case <-time.After(testTimeout):
+		t.Fatal("move was not handled")
+	}
+	// Closing waits for the handled move to be settled and recorded.
+	sub.Close()
+	d.Close()
+
+	d, err = OpenDedup(path, 100, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	mustClaim(t, d, "acked", false)
+	mustClaim(t, d, "rejected", true)
+}
@@ -0,0 +1,181 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	deathHeader              = "x-death"
+	firstDeathQueueHeader    = "x-first-death-queue"
+	firstDeathReasonHeader   = "x-first-death-reason"
+	firstDeathExchangeHeader = "x-first-death-exchange"
+)
+
+// maxDeadLetters bounds how many messages one DeadLetterQueue operation holds
+// unacknowledged at a time.
+const maxDeadLetters = 1000
+
+// DeadLetter is a message taken from a dead-letter queue together with where
+// it originally came from and why it died.
+type DeadLetter struct {
+	Delivery
+	// Queue is the queue the message was dead-lettered from.
+	Queue              string
+	Reason             string
+	OriginalExchange   string
+	OriginalRoutingKey string
+	// Deaths is how many times the message has been dead-lettered.
+	Deaths int
+}
+
+func parseDeadLetter(d Delivery) DeadLetter {
+	dl := DeadLetter{Delivery: d}
+	dl.Queue, _ = d.Headers[firstDeathQueueHeader].(string)
+	dl.Reason, _ = d.Headers[firstDeathReasonHeader].(string)
+	dl.OriginalExchange, _ = d.Headers[firstDeathExchangeHeader].(string)
+	deaths, _ := d.Headers[deathHeader].([]any)
+	for _, entry := range deaths {
+		death, ok := entry.(map[string]any)
+		if !ok {
+			continue
+		}
+		dl.Deaths += headerInt(death, "count")
+		if death["queue"] != dl.Queue {
+			continue
+		}
+		if keys, ok := death["routing-keys"].([]any); ok && len(keys) > 0 {
+			dl.OriginalRoutingKey, _ = keys[0].(string)
+		}
+	}
+	// Retries that ran out of attempts are dead-lettered by the subscriber,
+	// which records the original destination and failure itself.
+	if exchange, ok := d.Headers[originalExchangeHeader].(string); ok {
+		dl.OriginalExchange = exchange
+		dl.OriginalRoutingKey, _ = d.Headers[originalRoutingKeyHeader].(string)
+	}
+	if queue, ok := d.Headers[failedQueueHeader].(string); ok {
+		dl.Queue = queue
+	}
+	if reason, ok := d.Headers[failureReasonHeader].(string); ok {
+		dl.Reason = reason
+	}
+	if dl.OriginalRoutingKey == "" {
+		dl.OriginalRoutingKey = d.RoutingKey
+	}
+	if dl.Deaths == 0 {
+		dl.Deaths = 1
+	}
+	return dl
+}
+
+// DeadLetterQueue browses and replays the messages in a dead-letter queue.
+// Messages are numbered from 1 in queue order.
+type DeadLetterQueue struct {
+	b     Broker
+	queue string
+}
+
+func NewDeadLetterQueue(b Broker, queueName string) *DeadLetterQueue {
+	return &DeadLetterQueue{b: b, queue: queueName}
+}
+
+// List returns the dead letters currently in the queue and leaves them there.
+func (q *DeadLetterQueue) List() ([]DeadLetter, error) {
+	letters, err := q.fetch()
+	if err != nil {
+		return nil, err
+	}
+	q.release(letters)
+	return letters, nil
+}
+
+// Replay republishes the dead letters for which selected returns true to
+// their original exchange and routing key, removing them from the queue. It
+// returns how many were replayed.
+func (q *DeadLetterQueue) Replay(selected func(n int, dl DeadLetter) bool) (int, error) {
+	letters, err := q.fetch()
+	if err != nil {
+		return 0, err
+	}
+	defer q.release(letters)
+
+	replayed := 0
+	for i, dl := range letters {
+		if !selected(i+1, dl) {
+			continue
+		}
+		if dl.OriginalExchange == "" && dl.OriginalRoutingKey == "" {
+			return replayed, fmt.Errorf("message %d has no original destination", i+1)
+		}
+		msg := dl.Message
+		msg.Headers = stripDeathHeaders(msg.Headers)
+		ctx, cancel := context.WithTimeout(context.Background(), retryPublishTimeout)
+		err := q.b.PublishConfirm(ctx, dl.OriginalExchange, dl.OriginalRoutingKey, msg)
+		cancel()
+		if err != nil {
+			return replayed, fmt.Errorf("could not replay message %d: %w", i+1, err)
+		}
+		dl.Ack()
+		letters[i].Acknowledger = nil
+		replayed++
+	}
+	return replayed, nil
+}
+
+func (q *DeadLetterQueue) Purge() (int, error) {
+	return q.b.PurgeQueue(q.queue)
+}
+
+// fetch takes messages off the queue without acknowledging them. Every
+// letter returned must be settled or passed to release.
+func (q *DeadLetterQueue) fetch() ([]DeadLetter, error) {
+	var letters []DeadLetter
+	for len(letters) < maxDeadLetters {
+		d, ok, err := q.b.Get(q.queue)
+		if err != nil {
+			q.release(letters)
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, parseDeadLetter(d))
+	}
+	return letters, nil
+}
+
+// release requeues the letters that have not been settled. They go back in
+// reverse so each one lands in front of the one after it, keeping the
+// queue's order.
+func (q *DeadLetterQueue) release(letters []DeadLetter) {
+	for i := len(letters) - 1; i >= 0; i-- {
+		if letters[i].Acknowledger != nil {
+			letters[i].Nack(true)
+		}
+	}
+}
+
+// stripDeathHeaders drops the bookkeeping added by dead-lettering and retries
+// so a replayed message starts over as if it had just been published.
+func stripDeathHeaders(headers map[string]any) map[string]any {
+	headers = copyHeaders(headers)
+	for _, key := range []string{
+		deathHeader,
+		firstDeathQueueHeader,
+		firstDeathReasonHeader,
+		firstDeathExchangeHeader,
+		"x-last-death-queue",
+		"x-last-death-reason",
+		"x-last-death-exchange",
+		attemptsHeader,
+		lastErrorHeader,
+		failureReasonHeader,
+		failedQueueHeader,
+		originalExchangeHeader,
+		originalRoutingKeyHeader,
+	} {
+		delete(headers, key)
+	}
+	return headers
+}
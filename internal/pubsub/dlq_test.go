@@ -0,0 +1,127 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// rejectMoves publishes a move for each id and dead-letters it from the
+// moves queue.
+func rejectMoves(t *testing.T, b *MemoryBroker, ids ...string) {
+	t.Helper()
+	if err := b.DeclareAndBind(testExchange, "moves", "army_moves.*", Durable); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err := b.Publish(testExchange, "army_moves."+id, Message{MessageID: id}); err != nil {
+			t.Fatal(err)
+		}
+		d, ok, err := b.Get("moves")
+		if err != nil || !ok {
+			t.Fatalf("got no move: %v", err)
+		}
+		if err := d.Nack(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDeadLetterQueueListKeepsOrder(t *testing.T) {
+	b := newTestBroker(t)
+	rejectMoves(t, b, "1", "2", "3")
+	dlq := NewDeadLetterQueue(b, testDLQ)
+	for range 2 {
+		letters, err := dlq.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(letters) != 3 {
+			t.Fatalf("got %d dead letters, want 3", len(letters))
+		}
+		for i, dl := range letters {
+			if want := []string{"1", "2", "3"}[i]; dl.MessageID != want {
+				t.Errorf("dead letter %d is %q, want %q", i+1, dl.MessageID, want)
+			}
+		}
+	}
+}
+
+func TestDeadLetterQueueReplay(t *testing.T) {
+	b := newTestBroker(t)
+	rejectMoves(t, b, "1", "2", "3")
+	dlq := NewDeadLetterQueue(b, testDLQ)
+
+	replayed, err := dlq.Replay(func(n int, dl DeadLetter) bool { return dl.MessageID != "2" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed != 2 {
+		t.Errorf("replayed %d, want 2", replayed)
+	}
+	for _, want := range []string{"1", "3"} {
+		d, ok, err := b.Get("moves")
+		if err != nil || !ok {
+			t.Fatalf("replayed move %s did not reach its queue: %v", want, err)
+		}
+		if d.MessageID != want || d.RoutingKey != "army_moves."+want {
+			t.Errorf("got move %q with key %q, want %s", d.MessageID, d.RoutingKey, want)
+		}
+		if _, ok := d.Headers[deathHeader]; ok {
+			t.Error("replayed move kept its x-death header")
+		}
+		d.Ack()
+	}
+	letters, err := dlq.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(letters) != 1 || letters[0].MessageID != "2" {
+		t.Errorf("left %+v in the dead-letter queue, want only move 2", letters)
+	}
+}
+
+func TestDeadLetterQueueReplaysRetriedMessage(t *testing.T) {
+	b := newTestBroker(t)
+	if err := b.DeclareAndBind(testExchange, "moves", "army_moves.*", Durable); err != nil {
+		t.Fatal(err)
+	}
+	s := &settler{b: b, queueName: "moves", durable: true, retry: RetryPolicy{MaxAttempts: 1, DeadLetterExchange: DefaultDeadLetterExchange}}
+	if err := b.Publish(testExchange, "army_moves.alice", Message{MessageID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	d, _, _ := b.Get("moves")
+	s.settle(d, NackRetry, nil)
+
+	dlq := NewDeadLetterQueue(b, testDLQ)
+	replayed, err := dlq.Replay(func(int, DeadLetter) bool { return true })
+	if err != nil || replayed != 1 {
+		t.Fatalf("replayed %d: %v", replayed, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	deliveries, err := b.Consume(ctx, "moves", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = receive(t, deliveries)
+	if d.RoutingKey != "army_moves.alice" {
+		t.Errorf("replayed to %q, want army_moves.alice", d.RoutingKey)
+	}
+	if _, ok := d.Headers[attemptsHeader]; ok {
+		t.Error("replayed message kept its attempt count")
+	}
+	d.Ack()
+}
+
+func TestDeadLetterQueuePurge(t *testing.T) {
+	b := newTestBroker(t)
+	rejectMoves(t, b, "1", "2")
+	purged, err := NewDeadLetterQueue(b, testDLQ).Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("purged %d, want 2", purged)
+	}
+}
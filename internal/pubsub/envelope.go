@@ -0,0 +1,147 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const schemaVersionHeader = "x-schema-version"
+
+type messageType struct {
+	name    string
+	version int
+}
+
+type upgradeKey struct {
+	name string
+	from int
+}
+
+type upgrader struct {
+	decode  func(codec Codec, body []byte) (any, error)
+	upgrade func(val any) (any, error)
+}
+
+var (
+	envelopeMu   sync.RWMutex
+	producer     string
+	messageTypes = map[reflect.Type]messageType{}
+	upgraders    = map[upgradeKey]upgrader{}
+)
+
+// SetProducer names this process in the envelope of every message it
+// publishes from now on.
+func SetProducer(name string) {
+	envelopeMu.Lock()
+	defer envelopeMu.Unlock()
+	producer = name
+}
+
+// RegisterType gives T a message type name and the schema version of its
+// current layout. Bump the version whenever a change to T would break
+// decoding, and register an upgrader from the previous version.
+func RegisterType[T any](name string, version int) {
+	envelopeMu.Lock()
+	defer envelopeMu.Unlock()
+	messageTypes[typeOf[T]()] = messageType{name: name, version: version}
+}
+
+// RegisterUpgrader converts version from of typeName, decoded as From, into
+// version from+1. Upgraders chain, so a subscriber can read any older
+// version that has an upgrader for each step up to the current one.
+func RegisterUpgrader[From, To any](typeName string, from int, upgrade func(From) (To, error)) {
+	envelopeMu.Lock()
+	defer envelopeMu.Unlock()
+	upgraders[upgradeKey{name: typeName, from: from}] = upgrader{
+		decode: func(codec Codec, body []byte) (any, error) {
+			var val From
+			err := codec.Unmarshal(body, &val)
+			return val, err
+		},
+		upgrade: func(val any) (any, error) {
+			old, ok := val.(From)
+			if !ok {
+				return nil, fmt.Errorf("%s version %d upgrader expects %T, got %T", typeName, from, old, val)
+			}
+			return upgrade(old)
+		},
+	}
+}
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// envelope fills in the envelope of a new message carrying a T.
+func envelope[T any](msg *Message) {
+	envelopeMu.RLock()
+	defer envelopeMu.RUnlock()
+	if mt, ok := messageTypes[typeOf[T]()]; ok {
+		msg.Type = mt.name
+		*msg = withHeader(*msg, schemaVersionHeader, mt.version)
+	}
+	msg.MessageID = newMessageID()
+	msg.Timestamp = time.Now()
+	msg.Producer = producer
+}
+
+// unmarshal decodes msg into a T, running the upgraders first if it was
+// published with an older schema version. Messages without a version
+// predate the envelope and count as version 1.
+func unmarshal[T any](codec Codec, msg Message) (T, error) {
+	var val T
+	envelopeMu.RLock()
+	mt, ok := messageTypes[typeOf[T]()]
+	envelopeMu.RUnlock()
+	if !ok {
+		return val, codec.Unmarshal(msg.Body, &val)
+	}
+	if msg.Type != "" && msg.Type != mt.name {
+		return val, fmt.Errorf("got message type %q, want %q", msg.Type, mt.name)
+	}
+	version := headerInt(msg.Headers, schemaVersionHeader)
+	if version == 0 {
+		version = 1
+	}
+	if version >= mt.version {
+		return val, codec.Unmarshal(msg.Body, &val)
+	}
+
+	var upgraded any
+	for v := version; v < mt.version; v++ {
+		envelopeMu.RLock()
+		u, ok := upgraders[upgradeKey{name: mt.name, from: v}]
+		envelopeMu.RUnlock()
+		if !ok {
+			return val, fmt.Errorf("no upgrader for %s version %d", mt.name, v)
+		}
+		var err error
+		if upgraded == nil {
+			upgraded, err = u.decode(codec, msg.Body)
+			if err != nil {
+				return val, err
+			}
+		}
+		upgraded, err = u.upgrade(upgraded)
+		if err != nil {
+			return val, fmt.Errorf("could not upgrade %s version %d: %w", mt.name, v, err)
+		}
+	}
+	val, ok = upgraded.(T)
+	if !ok {
+		return val, fmt.Errorf("upgrading %s produced %T, want %T", mt.name, upgraded, val)
+	}
+	return val, nil
+}
+
+// newMessageID returns a random version 4 UUID.
+func newMessageID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The test move schema went from a single unit count to named units, then
+// gained a location.
+type (
+	moveV1 struct {
+		Player string
+		Units  int
+	}
+	moveV2 struct {
+		Player string
+		Units  []string
+	}
+	moveV3 struct {
+		Player   string
+		Units    []string
+		Location string
+	}
+	orphanV2 struct{ Player string }
+)
+
+func init() {
+	RegisterType[moveV3]("test.Move", 3)
+	RegisterUpgrader("test.Move", 1, func(old moveV1) (moveV2, error) {
+		if old.Units < 0 {
+			return moveV2{}, errors.New("negative unit count")
+		}
+		return moveV2{Player: old.Player, Units: make([]string, old.Units)}, nil
+	})
+	RegisterUpgrader("test.Move", 2, func(old moveV2) (moveV3, error) {
+		return moveV3{Player: old.Player, Units: old.Units, Location: "unknown"}, nil
+	})
+	RegisterType[orphanV2]("test.Orphan", 2)
+}
+
+// versioned encodes val as JSON in a message claiming version of typeName.
+func versioned(t *testing.T, typeName string, version int, val any) Message {
+	t.Helper()
+	body, err := JSON.Marshal(val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{Type: typeName, Body: body}
+	if version > 0 {
+		msg = withHeader(msg, schemaVersionHeader, int64(version))
+	}
+	return msg
+}
+
+func TestEnvelope(t *testing.T) {
+	var msg Message
+	envelope[moveV3](&msg)
+	if msg.Type != "test.Move" || headerInt(msg.Headers, schemaVersionHeader) != 3 {
+		t.Errorf("got type %q version %v, want test.Move 3", msg.Type, msg.Headers[schemaVersionHeader])
+	}
+	if msg.MessageID == "" || msg.Timestamp.IsZero() {
+		t.Error("envelope has no message ID or timestamp")
+	}
+	var other Message
+	envelope[moveV3](&other)
+	if other.MessageID == msg.MessageID {
+		t.Error("two messages share an ID")
+	}
+}
+
+func TestUnmarshalUpgrades(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want moveV3
+	}{
+		{"unversioned", versioned(t, "", 0, moveV1{Player: "alice", Units: 2}), moveV3{Player: "alice", Units: []string{"", ""}, Location: "unknown"}},
+		{"version 1", versioned(t, "test.Move", 1, moveV1{Player: "alice", Units: 1}), moveV3{Player: "alice", Units: []string{""}, Location: "unknown"}},
+		{"version 2", versioned(t, "test.Move", 2, moveV2{Player: "alice", Units: []string{"infantry"}}), moveV3{Player: "alice", Units: []string{"infantry"}, Location: "unknown"}},
+		{"current", versioned(t, "test.Move", 3, moveV3{Player: "alice", Location: "europe"}), moveV3{Player: "alice", Location: "europe"}},
+	}
+	for _, tt := range tests {
+		got, err := unmarshal[moveV3](JSON, tt.msg)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode func(Message) error
+		msg    Message
+		err    string
+	}{
+		{"failed upgrade", decodeAs[moveV3], versioned(t, "test.Move", 1, moveV1{Units: -1}), "negative unit count"},
+		{"other type", decodeAs[moveV3], versioned(t, "test.Orphan", 3, moveV3{}), `got message type "test.Orphan"`},
+		{"missing upgrader", decodeAs[orphanV2], versioned(t, "test.Orphan", 1, orphanV2{}), "no upgrader for test.Orphan version 1"},
+	}
+	for _, tt := range tests {
+		if err := tt.decode(tt.msg); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func decodeAs[T any](msg Message) error {
+	_, err := unmarshal[T](JSON, msg)
+	return err
+}
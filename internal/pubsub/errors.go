@@ -0,0 +1,43 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrRetry marks a handler error as worth retrying under the
+	// subscription's RetryPolicy.
+	ErrRetry = errors.New("retry")
+	// ErrPermanent marks a handler error that retrying will not fix. The
+	// message is dead-lettered straight away.
+	ErrPermanent = errors.New("permanent failure")
+)
+
+// Retry wraps err so that it matches ErrRetry.
+func Retry(err error) error {
+	return fmt.Errorf("%w: %w", ErrRetry, err)
+}
+
+// Permanent wraps err so that it matches ErrPermanent.
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+// ackFor decides how to settle a delivery whose handler returned acktype
+// and err. ErrRetry and ErrPermanent decide on their own; any other error
+// keeps acktype, except that a failed delivery is retried rather than
+// acknowledged.
+func ackFor(acktype AckType, err error) AckType {
+	switch {
+	case err == nil:
+		return acktype
+	case errors.Is(err, ErrPermanent):
+		return NackDiscard
+	case errors.Is(err, ErrRetry):
+		return NackRetry
+	case acktype == Ack:
+		return NackRetry
+	}
+	return acktype
+}
@@ -0,0 +1,44 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAckFor(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		acktype AckType
+		err     error
+		want    AckType
+	}{
+		{Ack, nil, Ack},
+		{NackDiscard, nil, NackDiscard},
+		{Ack, failed, NackRetry},
+		{NackRequeue, failed, NackRequeue},
+		{NackRetry, Permanent(failed), NackDiscard},
+		{NackDiscard, Retry(failed), NackRetry},
+	}
+	for _, tt := range tests {
+		if got := ackFor(tt.acktype, tt.err); got != tt.want {
+			t.Errorf("ackFor(%v, %v) = %v, want %v", tt.acktype, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSubscribeDeadLettersPermanentFailure(t *testing.T) {
+	b := newTestBroker(t)
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		return Ack, Permanent(errors.New("unknown player"))
+	}, WithRetry(testRetry))
+
+	if err := PublishJSON(b, testExchange, "army_moves.mallory", testMove{Player: "mallory"}); err != nil {
+		t.Fatal(err)
+	}
+	dl := deadLetters(t, b, 1)[0]
+	if !strings.Contains(dl.Reason, "unknown player") {
+		t.Errorf("got reason %q", dl.Reason)
+	}
+}
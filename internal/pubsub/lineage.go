@@ -0,0 +1,60 @@
+package pubsub
+
+import "context"
+
+// The correlation ID header names the message that started a chain of
+// events, and the causation ID header the message whose handler published
+// this one. Neither is the AMQP correlation-id property, Message.CorrelationID,
+// which pairs an RPC reply with its request.
+const (
+	correlationHeader = "x-correlation-id"
+	causationHeader   = "x-causation-id"
+)
+
+// Lineage links a message to the messages that led to it. ChainID is the
+// ID of the message that started the chain, carried in the x-correlation-id
+// header.
+type Lineage struct {
+	MessageID   string
+	ChainID     string
+	CausationID string
+}
+
+// Lineage reads the lineage of msg. A message without a correlation ID
+// started its own chain.
+func (msg Message) Lineage() Lineage {
+	l := Lineage{MessageID: msg.MessageID, ChainID: msg.MessageID}
+	if id, ok := msg.Headers[correlationHeader].(string); ok && id != "" {
+		l.ChainID = id
+	}
+	l.CausationID, _ = msg.Headers[causationHeader].(string)
+	return l
+}
+
+type lineageKey struct{}
+
+// LineageFromContext returns the lineage of the delivery being handled with
+// ctx. Messages published WithContext(ctx) are caused by that delivery and
+// share its chain ID.
+func LineageFromContext(ctx context.Context) (Lineage, bool) {
+	l, ok := ctx.Value(lineageKey{}).(Lineage)
+	return l, ok
+}
+
+func contextWithLineage(ctx context.Context, l Lineage) context.Context {
+	return context.WithValue(ctx, lineageKey{}, l)
+}
+
+// injectLineage adds the headers linking msg to the delivery being handled
+// with ctx, if any, to headers.
+func injectLineage(ctx context.Context, msg Message, headers map[string]any) {
+	cause, ok := LineageFromContext(ctx)
+	if !ok {
+		if msg.MessageID != "" {
+			headers[correlationHeader] = msg.MessageID
+		}
+		return
+	}
+	headers[correlationHeader] = cause.ChainID
+	headers[causationHeader] = cause.MessageID
+}
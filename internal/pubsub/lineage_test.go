@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMessageLineage(t *testing.T) {
+	started := Message{MessageID: "move"}
+	if got := started.Lineage(); got != (Lineage{MessageID: "move", ChainID: "move"}) {
+		t.Errorf("got lineage %+v, want a chain of its own", got)
+	}
+	caused := Message{MessageID: "war", Headers: map[string]any{
+		correlationHeader: "move",
+		causationHeader:   "recognition",
+	}}
+	if got := caused.Lineage(); got != (Lineage{MessageID: "war", ChainID: "move", CausationID: "recognition"}) {
+		t.Errorf("got lineage %+v", got)
+	}
+}
+
+func TestLineagePropagates(t *testing.T) {
+	b := newTestBroker(t)
+	lineages := make(chan Lineage, 3)
+	// Each move's handler publishes the next one until the third.
+	subscribeMoves(t, b, func(ctx context.Context, mv testMove) (AckType, error) {
+		l, ok := LineageFromContext(ctx)
+		if !ok {
+			t.Error("handler context has no lineage")
+		}
+		lineages <- l
+		if mv.Seq == 2 {
+			return Ack, nil
+		}
+		return Ack, PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice", Seq: mv.Seq + 1}, WithContext(ctx))
+	})
+	if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var chain []Lineage
+	for range 3 {
+		select {
+		case l := <-lineages:
+			chain = append(chain, l)
+		case <-time.After(testTimeout):
+			t.Fatal("moves were not handled")
+		}
+	}
+	first := chain[0]
+	if first.MessageID == "" || first.ChainID != first.MessageID || first.CausationID != "" {
+		t.Errorf("first move has lineage %+v, want a chain of its own", first)
+	}
+	for i, l := range chain[1:] {
+		if l.ChainID != first.MessageID {
+			t.Errorf("move %d is in chain %q, want %q", i+1, l.ChainID, first.MessageID)
+		}
+		if l.CausationID != chain[i].MessageID {
+			t.Errorf("move %d was caused by %q, want %q", i+1, l.CausationID, chain[i].MessageID)
+		}
+	}
+}
@@ -0,0 +1,597 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrBrokerClosed        = errors.New("broker is closed")
+	ErrAlreadyAcknowledged = errors.New("delivery already acknowledged")
+)
+
+// MemoryBroker is an in-process Broker for tests. It routes like RabbitMQ's
+// direct, topic and fanout exchanges, delivers to the default exchange by
+// queue name, and dead-letters rejected and expired messages the way the
+// queue was declared to.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	exchanges map[string]*memExchange
+	queues    map[string]*memQueue
+	closed    bool
+	done      chan struct{}
+}
+
+type memExchange struct {
+	kind     string
+	bindings []memBinding
+}
+
+type memBinding struct {
+	key   string
+	queue string
+}
+
+type memQueue struct {
+	name      string
+	opts      QueueOptions
+	messages  []memMessage
+	consumers map[*memConsumer]struct{}
+	lastUsed  time.Time
+}
+
+type memMessage struct {
+	exchange    string
+	routingKey  string
+	msg         Message
+	redelivered bool
+	expires     time.Time
+}
+
+type memConsumer struct {
+	ctx      context.Context
+	queue    *memQueue
+	prefetch int
+	out      chan Delivery
+	unacked  map[*memAcker]struct{}
+}
+
+type memAcker struct {
+	b        *MemoryBroker
+	consumer *memConsumer
+	msg      memMessage
+	done     bool
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		exchanges: map[string]*memExchange{},
+		queues:    map[string]*memQueue{},
+		done:      make(chan struct{}),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *MemoryBroker) DeclareExchange(name, kind string) error {
+	switch kind {
+	case ExchangeDirect, ExchangeTopic, ExchangeFanout:
+	default:
+		return fmt.Errorf("unknown exchange kind %q", kind)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind {
+			return fmt.Errorf("exchange %q already declared as %s", name, ex.kind)
+		}
+		return nil
+	}
+	b.exchanges[name] = &memExchange{kind: kind}
+	return nil
+}
+
+func (b *MemoryBroker) DeclareAndBind(
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("exchange %q not found", exchange)
+	}
+	if err := b.declareQueue(queueName, queueOptions(simpleQueueType)); err != nil {
+		return err
+	}
+	ex.bind(queueName, key)
+	return nil
+}
+
+func (b *MemoryBroker) BindQueue(queueName, key, exchange string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return fmt.Errorf("exchange %q not found", exchange)
+	}
+	if _, ok := b.queues[queueName]; !ok {
+		return fmt.Errorf("queue %q not found", queueName)
+	}
+	ex.bind(queueName, key)
+	return nil
+}
+
+func (ex *memExchange) bind(queueName, key string) {
+	for _, binding := range ex.bindings {
+		if binding.key == key && binding.queue == queueName {
+			return
+		}
+	}
+	ex.bindings = append(ex.bindings, memBinding{key: key, queue: queueName})
+}
+
+func (b *MemoryBroker) DeclareQueue(queueName string, opts QueueOptions) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	return b.declareQueue(queueName, opts)
+}
+
+// declareQueue creates queueName, or checks that an existing queue was
+// declared with the same options. The caller must hold b.mu.
+func (b *MemoryBroker) declareQueue(queueName string, opts QueueOptions) error {
+	q, ok := b.queues[queueName]
+	if !ok {
+		q = &memQueue{
+			name:      queueName,
+			opts:      opts,
+			consumers: map[*memConsumer]struct{}{},
+		}
+		b.queues[queueName] = q
+	} else if q.opts != opts {
+		return fmt.Errorf("queue %q already declared with different options", queueName)
+	}
+	b.used(q)
+	return nil
+}
+
+// used renews the lease of a queue declared with Expires and schedules its
+// deletion for when the lease runs out unused. The caller must hold b.mu.
+func (b *MemoryBroker) used(q *memQueue) {
+	if q.opts.Expires <= 0 {
+		return
+	}
+	q.lastUsed = time.Now()
+	time.AfterFunc(q.opts.Expires, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.closed || b.queues[q.name] != q || len(q.consumers) > 0 {
+			return
+		}
+		if time.Since(q.lastUsed) >= q.opts.Expires {
+			b.deleteQueue(q.name)
+		}
+	})
+}
+
+func (b *MemoryBroker) Publish(exchange, key string, msg Message) (err error) {
+	defer func() { recordPublish(exchange, err) }()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	_, err = b.route(exchange, key, msg)
+	return err
+}
+
+func (b *MemoryBroker) PublishConfirm(ctx context.Context, exchange, key string, msg Message) (err error) {
+	defer func() { recordPublish(exchange, err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	routed, err := b.route(exchange, key, msg)
+	if err != nil {
+		return err
+	}
+	if routed == 0 {
+		return &ReturnError{Exchange: exchange, Key: key, ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) PublishBatch(ctx context.Context, batch []BatchMessage) error {
+	errs := make([]error, len(batch))
+	for i, m := range batch {
+		errs[i] = b.PublishConfirm(ctx, m.Exchange, m.Key, m.Message)
+	}
+	return batchResult(errs)
+}
+
+// route enqueues msg on every queue bound to exchange with a matching key and
+// reports how many queues received it. The caller must hold b.mu.
+func (b *MemoryBroker) route(exchange, key string, msg Message) (int, error) {
+	msg.Headers = copyHeaders(msg.Headers)
+	m := memMessage{exchange: exchange, routingKey: key, msg: msg}
+	if exchange == "" {
+		q, ok := b.queues[key]
+		if !ok {
+			return 0, nil
+		}
+		b.enqueue(q, m)
+		return 1, nil
+	}
+	ex, ok := b.exchanges[exchange]
+	if !ok {
+		return 0, fmt.Errorf("exchange %q not found", exchange)
+	}
+	routed := map[string]struct{}{}
+	for _, binding := range ex.bindings {
+		if _, ok := routed[binding.queue]; ok {
+			continue
+		}
+		if !ex.matches(binding.key, key) {
+			continue
+		}
+		q, ok := b.queues[binding.queue]
+		if !ok {
+			continue
+		}
+		b.enqueue(q, m)
+		routed[binding.queue] = struct{}{}
+	}
+	return len(routed), nil
+}
+
+// enqueue appends m to q, scheduling its expiry if q has a message TTL or m
+// has an expiration. The caller must hold b.mu.
+func (b *MemoryBroker) enqueue(q *memQueue, m memMessage) {
+	ttl := q.opts.MessageTTL
+	if e := m.msg.Expiration; e > 0 && (ttl <= 0 || e < ttl) {
+		ttl = e
+	}
+	if ttl > 0 {
+		m.expires = time.Now().Add(ttl)
+		time.AfterFunc(ttl, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.expire(q)
+		})
+	}
+	q.messages = append(q.messages, m)
+	b.cond.Broadcast()
+}
+
+// expire dead-letters expired messages from the head of q, which is the only
+// place RabbitMQ looks for them. It runs when an expiry timer fires and again
+// before the head is delivered, since a message can reach the head after its
+// timer fired. The caller must hold b.mu.
+func (b *MemoryBroker) expire(q *memQueue) {
+	if b.closed || b.queues[q.name] != q {
+		return
+	}
+	now := time.Now()
+	for len(q.messages) > 0 {
+		m := q.messages[0]
+		if m.expires.IsZero() || now.Before(m.expires) {
+			return
+		}
+		q.messages = q.messages[1:]
+		b.deadLetter(q, m, "expired")
+	}
+}
+
+func (ex *memExchange) matches(bindingKey, routingKey string) bool {
+	switch ex.kind {
+	case ExchangeFanout:
+		return true
+	case ExchangeTopic:
+		return topicMatch(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+// topicMatch implements AMQP topic matching: "*" matches exactly one word
+// and "#" matches zero or more words.
+func topicMatch(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatch(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatch(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatch(pattern[1:], words[1:])
+	}
+}
+
+func (b *MemoryBroker) Consume(ctx context.Context, queueName string, prefetch int) (<-chan Delivery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return nil, fmt.Errorf("queue %q not found", queueName)
+	}
+	c := &memConsumer{
+		ctx:      ctx,
+		queue:    q,
+		prefetch: prefetch,
+		out:      make(chan Delivery),
+		unacked:  map[*memAcker]struct{}{},
+	}
+	q.consumers[c] = struct{}{}
+	go b.deliver(c)
+	return c.out, nil
+}
+
+func (b *MemoryBroker) Get(queueName string) (Delivery, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return Delivery{}, false, ErrBrokerClosed
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return Delivery{}, false, fmt.Errorf("queue %q not found", queueName)
+	}
+	b.used(q)
+	b.expire(q)
+	if len(q.messages) == 0 {
+		return Delivery{}, false, nil
+	}
+	m := q.messages[0]
+	q.messages = q.messages[1:]
+	// A basic.get is settled like any delivery but is not tied to a
+	// registered consumer.
+	getter := &memConsumer{queue: q, unacked: map[*memAcker]struct{}{}}
+	acker := &memAcker{b: b, consumer: getter, msg: m}
+	getter.unacked[acker] = struct{}{}
+	return Delivery{
+		Message:      m.msg,
+		Exchange:     m.exchange,
+		RoutingKey:   m.routingKey,
+		Redelivered:  m.redelivered,
+		Acknowledger: acker,
+	}, true, nil
+}
+
+func (b *MemoryBroker) PurgeQueue(queueName string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrBrokerClosed
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		return 0, fmt.Errorf("queue %q not found", queueName)
+	}
+	purged := len(q.messages)
+	q.messages = nil
+	return purged, nil
+}
+
+func (b *MemoryBroker) deliver(c *memConsumer) {
+	defer close(c.out)
+	stop := context.AfterFunc(c.ctx, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	defer stop()
+	for {
+		b.mu.Lock()
+		for !b.closed && c.ctx.Err() == nil && (len(c.queue.messages) == 0 || (c.prefetch > 0 && len(c.unacked) >= c.prefetch)) {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.requeueUnacked(c)
+			b.detach(c)
+			b.mu.Unlock()
+			return
+		}
+		if c.ctx.Err() != nil {
+			b.detach(c)
+			b.mu.Unlock()
+			return
+		}
+		b.expire(c.queue)
+		if len(c.queue.messages) == 0 {
+			b.mu.Unlock()
+			continue
+		}
+		m := c.queue.messages[0]
+		c.queue.messages = c.queue.messages[1:]
+		acker := &memAcker{b: b, consumer: c, msg: m}
+		c.unacked[acker] = struct{}{}
+		b.mu.Unlock()
+
+		d := Delivery{
+			Message:      m.msg,
+			Exchange:     m.exchange,
+			RoutingKey:   m.routingKey,
+			Redelivered:  m.redelivered,
+			Acknowledger: acker,
+		}
+		select {
+		case c.out <- d:
+		case <-c.ctx.Done():
+			acker.Nack(true)
+		case <-b.done:
+		}
+	}
+}
+
+// requeueUnacked puts back everything c was handed but has not settled, as
+// RabbitMQ does when a channel closes. The caller must hold b.mu.
+func (b *MemoryBroker) requeueUnacked(c *memConsumer) {
+	for acker := range c.unacked {
+		acker.done = true
+		acker.msg.redelivered = true
+		c.queue.messages = append([]memMessage{acker.msg}, c.queue.messages...)
+	}
+	c.unacked = map[*memAcker]struct{}{}
+}
+
+// detach stops deliveries to c and deletes its queue if it was auto-delete
+// and c was its last consumer. Deliveries c already holds can still be
+// settled. The caller must hold b.mu.
+func (b *MemoryBroker) detach(c *memConsumer) {
+	delete(c.queue.consumers, c)
+	if c.queue.opts.AutoDelete && len(c.queue.consumers) == 0 {
+		b.deleteQueue(c.queue.name)
+		return
+	}
+	if len(c.queue.consumers) == 0 {
+		b.used(c.queue)
+	}
+}
+
+func (b *MemoryBroker) deleteQueue(name string) {
+	delete(b.queues, name)
+	for _, ex := range b.exchanges {
+		bindings := ex.bindings[:0]
+		for _, binding := range ex.bindings {
+			if binding.queue != name {
+				bindings = append(bindings, binding)
+			}
+		}
+		ex.bindings = bindings
+	}
+}
+
+func (a *memAcker) Ack() error {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+	if err := a.settle(); err != nil {
+		return err
+	}
+	a.b.cond.Broadcast()
+	return nil
+}
+
+func (a *memAcker) Nack(requeue bool) error {
+	a.b.mu.Lock()
+	defer a.b.mu.Unlock()
+	if err := a.settle(); err != nil {
+		return err
+	}
+	q := a.consumer.queue
+	if requeue {
+		a.msg.redelivered = true
+		q.messages = append([]memMessage{a.msg}, q.messages...)
+	} else {
+		a.b.deadLetter(q, a.msg, "rejected")
+	}
+	a.b.cond.Broadcast()
+	return nil
+}
+
+func (a *memAcker) settle() error {
+	if a.done {
+		return ErrAlreadyAcknowledged
+	}
+	a.done = true
+	delete(a.consumer.unacked, a)
+	return nil
+}
+
+// deadLetter republishes m to the queue's dead-letter exchange with the
+// x-death headers RabbitMQ would add. The caller must hold b.mu.
+func (b *MemoryBroker) deadLetter(q *memQueue, m memMessage, reason string) {
+	if !q.opts.DeadLetter {
+		return
+	}
+	exchange := q.opts.DeadLetterExchange
+	if _, ok := b.exchanges[exchange]; !ok && exchange != "" {
+		return
+	}
+	key := m.routingKey
+	if q.opts.DeadLetterRoutingKey != "" {
+		key = q.opts.DeadLetterRoutingKey
+	}
+	// Like RabbitMQ, dead-lettering drops the expiration so the dead letter
+	// stays until it is dealt with.
+	msg := m.msg
+	msg.Expiration = 0
+	deaths, _ := msg.Headers["x-death"].([]any)
+	deaths = append([]any(nil), deaths...)
+	counted := false
+	for i, d := range deaths {
+		death, ok := d.(map[string]any)
+		if !ok || death["queue"] != q.name || death["reason"] != reason {
+			continue
+		}
+		death = copyHeaders(death)
+		count, _ := death["count"].(int64)
+		death["count"] = count + 1
+		death["time"] = time.Now()
+		deaths[i] = death
+		counted = true
+	}
+	if !counted {
+		deaths = append([]any{map[string]any{
+			"count":        int64(1),
+			"reason":       reason,
+			"queue":        q.name,
+			"time":         time.Now(),
+			"exchange":     m.exchange,
+			"routing-keys": []any{m.routingKey},
+		}}, deaths...)
+	}
+	set := map[string]any{"x-death": deaths}
+	if _, ok := msg.Headers["x-first-death-queue"]; !ok {
+		set["x-first-death-queue"] = q.name
+		set["x-first-death-reason"] = reason
+		set["x-first-death-exchange"] = m.exchange
+	}
+	b.route(exchange, key, withHeaders(msg, set))
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	b.closed = true
+	close(b.done)
+	b.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,267 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testExchange = "peril_test"
+	testDLQ      = "peril_test_dlq"
+)
+
+// testTimeout bounds every wait for a delivery.
+const testTimeout = 5 * time.Second
+
+// newTestBroker returns a MemoryBroker with a topic exchange to publish to
+// and a queue collecting everything dead-lettered to the default
+// dead-letter exchange.
+func newTestBroker(t *testing.T) *MemoryBroker {
+	t.Helper()
+	b := NewMemoryBroker()
+	t.Cleanup(func() { b.Close() })
+	err := DeclareTopology(b, Topology{
+		Exchanges: []Exchange{
+			{Name: testExchange, Kind: ExchangeTopic},
+			{Name: DefaultDeadLetterExchange, Kind: ExchangeFanout},
+		},
+		Queues: []Queue{
+			{Name: testDLQ, Options: QueueOptions{Durable: true}},
+		},
+		Bindings: []Binding{
+			{Queue: testDLQ, Exchange: DefaultDeadLetterExchange},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func consumeQueue(t *testing.T, b *MemoryBroker, queueName string) <-chan Delivery {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	deliveries, err := b.Consume(ctx, queueName, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deliveries
+}
+
+func receive(t *testing.T, deliveries <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		return d
+	case <-time.After(testTimeout):
+		t.Fatal("timed out waiting for a delivery")
+		return Delivery{}
+	}
+}
+
+// deadLetters waits for n messages in the test dead-letter queue.
+func deadLetters(t *testing.T, b *MemoryBroker, n int) []DeadLetter {
+	t.Helper()
+	dlq := NewDeadLetterQueue(b, testDLQ)
+	deadline := time.Now().Add(testTimeout)
+	for {
+		dls, err := dlq.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dls) >= n {
+			return dls
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d dead letters, want %d", len(dls), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"army_moves.alice", "army_moves.alice", true},
+		{"army_moves.alice", "army_moves.bob", false},
+		{"army_moves.*", "army_moves.bob", true},
+		{"army_moves.*", "army_moves", false},
+		{"army_moves.*", "army_moves.bob.extra", false},
+		{"*.bob", "army_moves.bob", true},
+		{"*", "", true},
+		{"game_logs.#", "game_logs", true},
+		{"game_logs.#", "game_logs.alice", true},
+		{"game_logs.#", "game_logs.alice.europe", true},
+		{"game_logs.#", "war.alice", false},
+		{"#", "anything.at.all", true},
+		{"#.europe", "europe", true},
+		{"#.europe", "game_logs.alice.europe", true},
+		{"#.europe", "game_logs.europe.alice", false},
+		{"game_logs.#.europe", "game_logs.europe", true},
+		{"game_logs.#.europe", "game_logs.alice.bob.europe", true},
+		{"*.#", "", true},
+		{"*.*", "war", false},
+		{"#.*", "war", true},
+	}
+	for _, tt := range tests {
+		got := topicMatch(strings.Split(tt.pattern, "."), strings.Split(tt.key, "."))
+		if got != tt.want {
+			t.Errorf("topicMatch(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryBrokerRoutesByExchangeKind(t *testing.T) {
+	b := newTestBroker(t)
+	for _, q := range []struct{ queue, key string }{
+		{"moves.all", "army_moves.*"},
+		{"moves.alice", "army_moves.alice"},
+		{"logs", "game_logs.#"},
+	} {
+		if err := b.DeclareAndBind(testExchange, q.queue, q.key, Transient); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Publish(testExchange, "army_moves.bob", Message{Body: []byte("move")}); err != nil {
+		t.Fatal(err)
+	}
+	for queue, want := range map[string]bool{"moves.all": true, "moves.alice": false, "logs": false} {
+		_, ok, err := b.Get(queue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("queue %s got message: %v, want %v", queue, ok, want)
+		}
+	}
+
+	err := b.PublishConfirm(context.Background(), testExchange, "war.bob", Message{})
+	var returned *ReturnError
+	if !errors.As(err, &returned) {
+		t.Errorf("PublishConfirm to unbound key: got %v, want a *ReturnError", err)
+	}
+}
+
+func TestMemoryBrokerNackRequeue(t *testing.T) {
+	b := newTestBroker(t)
+	if err := b.DeclareAndBind(testExchange, "moves", "army_moves.*", Durable); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := consumeQueue(t, b, "moves")
+	if err := b.Publish(testExchange, "army_moves.alice", Message{MessageID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, deliveries)
+	if first.Redelivered {
+		t.Error("first delivery is marked redelivered")
+	}
+	if err := first.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Ack(); err != ErrAlreadyAcknowledged {
+		t.Errorf("settling twice: got %v, want %v", err, ErrAlreadyAcknowledged)
+	}
+
+	again := receive(t, deliveries)
+	if again.MessageID != "1" || !again.Redelivered {
+		t.Errorf("got message %q redelivered %v, want message 1 redelivered", again.MessageID, again.Redelivered)
+	}
+	if err := again.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(deadLetters(t, b, 0)); n != 0 {
+		t.Errorf("got %d dead letters, want none", n)
+	}
+}
+
+func TestMemoryBrokerDeadLetters(t *testing.T) {
+	b := newTestBroker(t)
+	if err := b.DeclareAndBind(testExchange, "moves", "army_moves.*", Durable); err != nil {
+		t.Fatal(err)
+	}
+	deliveries := consumeQueue(t, b, "moves")
+	msg := Message{MessageID: "1", Headers: map[string]any{"x-player": "alice"}}
+	if err := b.Publish(testExchange, "army_moves.alice", msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := receive(t, deliveries).Nack(false); err != nil {
+		t.Fatal(err)
+	}
+
+	dl := deadLetters(t, b, 1)[0]
+	if dl.MessageID != "1" || dl.Headers["x-player"] != "alice" {
+		t.Errorf("dead letter lost the message: %+v", dl.Message)
+	}
+	if dl.Queue != "moves" || dl.Reason != "rejected" {
+		t.Errorf("got queue %q reason %q, want moves rejected", dl.Queue, dl.Reason)
+	}
+	if dl.OriginalExchange != testExchange || dl.OriginalRoutingKey != "army_moves.alice" {
+		t.Errorf("got origin %s/%s, want %s/army_moves.alice", dl.OriginalExchange, dl.OriginalRoutingKey, testExchange)
+	}
+}
+
+func TestMemoryBrokerDeadLettersExpired(t *testing.T) {
+	b := newTestBroker(t)
+	if err := b.DeclareAndBind(testExchange, "moves", "army_moves.*", Durable); err != nil {
+		t.Fatal(err)
+	}
+	msg := Message{MessageID: "1", Expiration: 10 * time.Millisecond}
+	if err := b.Publish(testExchange, "army_moves.alice", msg); err != nil {
+		t.Fatal(err)
+	}
+
+	dl := deadLetters(t, b, 1)[0]
+	if dl.Reason != "expired" {
+		t.Errorf("got reason %q, want expired", dl.Reason)
+	}
+	if dl.Expiration != 0 {
+		t.Errorf("dead letter kept expiration %v", dl.Expiration)
+	}
+}
+
+func TestMemoryBrokerDeadLettersExpiredBehindHead(t *testing.T) {
+	for _, consumed := range []bool{false, true} {
+		b := newTestBroker(t)
+		if err := b.DeclareAndBind(testExchange, "moves", "army_moves.*", Durable); err != nil {
+			t.Fatal(err)
+		}
+		// The second message expires while the first one is still ahead of
+		// it, so it is only found once it reaches the head.
+		for _, msg := range []Message{{MessageID: "1"}, {MessageID: "2", Expiration: 10 * time.Millisecond}} {
+			if err := b.Publish(testExchange, "army_moves.alice", msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(30 * time.Millisecond)
+
+		if consumed {
+			deliveries := consumeQueue(t, b, "moves")
+			if d := receive(t, deliveries); d.MessageID != "1" {
+				t.Fatalf("got message %q, want 1", d.MessageID)
+			}
+			select {
+			case d := <-deliveries:
+				t.Errorf("delivered expired message %q", d.MessageID)
+			case <-time.After(30 * time.Millisecond):
+			}
+		} else {
+			if d, _, _ := b.Get("moves"); d.MessageID != "1" {
+				t.Fatalf("got message %q, want 1", d.MessageID)
+			}
+			if d, ok, _ := b.Get("moves"); ok {
+				t.Errorf("got expired message %q", d.MessageID)
+			}
+		}
+		if dl := deadLetters(t, b, 1)[0]; dl.MessageID != "2" || dl.Reason != "expired" {
+			t.Errorf("got dead letter %q reason %q, want 2 expired", dl.MessageID, dl.Reason)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package pubsub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The metrics of every broker and subscription in the process, written in
+// the Prometheus text format by WriteMetrics and MetricsHandler.
+var (
+	publishedTotal      = newCounter("peril_pubsub_published_total", "Messages published.", "exchange")
+	publishFailedTotal  = newCounter("peril_pubsub_publish_failures_total", "Publishes that failed or were not confirmed.", "exchange")
+	deliveredTotal      = newCounter("peril_pubsub_delivered_total", "Messages delivered to subscriptions.", "queue")
+	ackedTotal          = newCounter("peril_pubsub_acked_total", "Deliveries acknowledged.", "queue")
+	nackedRequeueTotal  = newCounter("peril_pubsub_nacked_requeue_total", "Deliveries nacked and requeued.", "queue")
+	nackedDiscardTotal  = newCounter("peril_pubsub_nacked_discard_total", "Deliveries nacked and dead-lettered.", "queue")
+	retriedTotal        = newCounter("peril_pubsub_retried_total", "Deliveries scheduled for a delayed retry.", "queue")
+	decodeFailuresTotal = newCounter("peril_pubsub_decode_failures_total", "Deliveries that could not be decoded.", "queue")
+	handlerSeconds      = newHistogram("peril_pubsub_handler_seconds", "Time spent in subscription handlers.", "queue",
+		[]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10})
+)
+
+var allMetrics = []interface{ write(w io.Writer) }{
+	publishedTotal,
+	publishFailedTotal,
+	deliveredTotal,
+	ackedTotal,
+	nackedRequeueTotal,
+	nackedDiscardTotal,
+	retriedTotal,
+	decodeFailuresTotal,
+	handlerSeconds,
+}
+
+// WriteMetrics writes every metric in the Prometheus text format.
+func WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range allMetrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// MetricsHandler serves WriteMetrics over HTTP for Prometheus to scrape.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w)
+	})
+}
+
+// recordPublish counts a publish to exchange that ended with err.
+func recordPublish(exchange string, err error) {
+	if err != nil {
+		publishFailedTotal.inc(exchange)
+		return
+	}
+	publishedTotal.inc(exchange)
+}
+
+// counter is a counter partitioned by the value of one label.
+type counter struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func newCounter(name, help, label string) *counter {
+	return &counter{name: name, help: help, label: label, values: map[string]uint64{}}
+}
+
+func (c *counter) inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelValue]++
+}
+
+func (c *counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, v := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s=%s} %d\n", c.name, c.label, quoteLabel(v), c.values[v])
+	}
+}
+
+// histogram is a histogram partitioned by the value of one label.
+type histogram struct {
+	name, help, label string
+	buckets           []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help, label string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, label: label, buckets: buckets, series: map[string]*histogramSeries{}}
+}
+
+func (h *histogram) observe(labelValue string, d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	seconds := d.Seconds()
+	for i, upper := range h.buckets {
+		if seconds <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += seconds
+	s.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, v := range sortedKeys(h.series) {
+		s := h.series[v]
+		label := fmt.Sprintf("%s=%s", h.label, quoteLabel(v))
+		for i, upper := range h.buckets {
+			le := strconv.FormatFloat(upper, 'g', -1, 64)
+			fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", h.name, label, le, s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, label, s.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", h.name, label, s.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, label, s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
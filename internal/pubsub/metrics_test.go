@@ -0,0 +1,96 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterWrite(t *testing.T) {
+	c := newCounter("peril_test_total", "Test messages.", "queue")
+	c.inc("moves")
+	c.inc("moves")
+	c.inc(`say "hi"` + "\n")
+	var buf bytes.Buffer
+	c.write(&buf)
+	want := `# HELP peril_test_total Test messages.
+# TYPE peril_test_total counter
+peril_test_total{queue="moves"} 2
+peril_test_total{queue="say \"hi\"\n"} 1
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestHistogramWrite(t *testing.T) {
+	h := newHistogram("peril_test_seconds", "Test durations.", "queue", []float64{.1, 1})
+	h.observe("moves", 50*time.Millisecond)
+	h.observe("moves", 500*time.Millisecond)
+	h.observe("moves", 2*time.Second)
+	var buf bytes.Buffer
+	h.write(&buf)
+	want := `# HELP peril_test_seconds Test durations.
+# TYPE peril_test_seconds histogram
+peril_test_seconds_bucket{queue="moves",le="0.1"} 1
+peril_test_seconds_bucket{queue="moves",le="1"} 2
+peril_test_seconds_bucket{queue="moves",le="+Inf"} 3
+peril_test_seconds_sum{queue="moves"} 2.55
+peril_test_seconds_count{queue="moves"} 3
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestMetricsCountSubscription(t *testing.T) {
+	b := newTestBroker(t)
+	// The metrics are shared by the whole process, so the queue is one no
+	// other test uses.
+	queueName := "metrics." + newMessageID()
+	handled := make(chan struct{})
+	sub, err := SubscribeJSONErr(context.Background(), b, testExchange, queueName, "army_moves.*", Durable, func(_ context.Context, mv testMove) (AckType, error) {
+		if mv.Seq == 1 {
+			close(handled)
+			return Ack, nil
+		}
+		return NackDiscard, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := range 2 {
+		if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-handled:
+	case <-time.After(testTimeout):
+		t.Fatal("moves were not handled")
+	}
+	// Closing waits for the deliveries to be settled and counted.
+	sub.Close()
+
+	rec := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("got content type %q", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`peril_pubsub_delivered_total{queue=%q} 2`,
+		`peril_pubsub_acked_total{queue=%q} 1`,
+		`peril_pubsub_nacked_discard_total{queue=%q} 1`,
+		`peril_pubsub_handler_seconds_count{queue=%q} 2`,
+	} {
+		line = fmt.Sprintf(line, queueName)
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Handler handles one decoded delivery. val holds the decoded value and ctx
+// carries the delivery's trace and lineage. ctx is not cancelled when the
+// subscription stops, so a handler still running while Close drains the
+// subscription can finish its follow-up publishes. The error, if any, is logged
+// and decides the AckType as described for SubscribeErr.
+type Handler func(ctx context.Context, d Delivery, val any) (AckType, error)
+
+// Middleware wraps a Handler to run code around every delivery.
+type Middleware func(next Handler) Handler
+
+var (
+	globalMiddlewareMu sync.RWMutex
+	globalMiddleware   []Middleware
+)
+
+// Use adds middleware to every subscription started afterwards. Global
+// middleware runs outside the middleware given by WithMiddleware.
+func Use(mw ...Middleware) {
+	globalMiddlewareMu.Lock()
+	defer globalMiddlewareMu.Unlock()
+	globalMiddleware = append(globalMiddleware, mw...)
+}
+
+// WithMiddleware wraps the subscription's handler in mw. The first
+// middleware is the outermost.
+func WithMiddleware(mw ...Middleware) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.middleware = append(o.middleware, mw...)
+	}
+}
+
+// chain wraps h in the global middleware and then in mw.
+func chain(h Handler, mw []Middleware) Handler {
+	globalMiddlewareMu.RLock()
+	all := append(append([]Middleware(nil), globalMiddleware...), mw...)
+	globalMiddlewareMu.RUnlock()
+	for i := len(all) - 1; i >= 0; i-- {
+		h = all[i](h)
+	}
+	return h
+}
+
+// Recover turns a panicking handler into a permanent failure,
+// dead-lettering the delivery instead of crashing the consumer.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d Delivery, val any) (acktype AckType, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Handler panicked on %s: %v\n%s", d.RoutingKey, r, debug.Stack())
+					acktype, err = NackDiscard, Permanent(fmt.Errorf("panic: %v", r))
+				}
+			}()
+			return next(ctx, d, val)
+		}
+	}
+}
+
+// Logging logs every delivery with the outcome of handling it.
+func Logging(logger *log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d Delivery, val any) (AckType, error) {
+			acktype, err := next(ctx, d, val)
+			if err != nil {
+				logger.Printf("Handled message %s from %s: %v: %v", d.MessageID, d.RoutingKey, acktype, err)
+			} else {
+				logger.Printf("Handled message %s from %s: %v", d.MessageID, d.RoutingKey, acktype)
+			}
+			return acktype, err
+		}
+	}
+}
+
+// Timing reports how long the handler took for every delivery.
+func Timing(observe func(d Delivery, elapsed time.Duration, acktype AckType, err error)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, d Delivery, val any) (AckType, error) {
+			start := time.Now()
+			acktype, err := next(ctx, d, val)
+			observe(d, time.Since(start), acktype, err)
+			return acktype, err
+		}
+	}
+}
+
+// Timeout gives the handler a context that expires after d and retries the
+// delivery if the handler has not returned by then. A handler that ignores
+// its context keeps running in the background and its result is dropped.
+func Timeout(d time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Delivery, val any) (AckType, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			type result struct {
+				acktype AckType
+				err     error
+			}
+			results := make(chan result, 1)
+			panicked := make(chan any, 1)
+			go func() {
+				// Pass panics back so Recover, running on this goroutine,
+				// still sees them.
+				defer func() {
+					if r := recover(); r != nil {
+						panicked <- r
+					}
+				}()
+				acktype, err := next(ctx, msg, val)
+				results <- result{acktype, err}
+			}()
+			select {
+			case r := <-results:
+				return r.acktype, r.err
+			case r := <-panicked:
+				panic(r)
+			case <-ctx.Done():
+				return NackRetry, Retry(fmt.Errorf("handler timed out after %v", d))
+			}
+		}
+	}
+}
+
+func (a AckType) String() string {
+	switch a {
+	case Ack:
+		return "ack"
+	case NackRequeue:
+		return "nack-requeue"
+	case NackDiscard:
+		return "nack-discard"
+	case NackRetry:
+		return "nack-retry"
+	}
+	return fmt.Sprintf("AckType(%d)", int(a))
+}
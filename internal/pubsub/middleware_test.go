@@ -0,0 +1,89 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithMiddlewareOrder(t *testing.T) {
+	var calls []string
+	named := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, d Delivery, val any) (AckType, error) {
+				calls = append(calls, name)
+				return next(ctx, d, val)
+			}
+		}
+	}
+	h := chain(func(context.Context, Delivery, any) (AckType, error) {
+		calls = append(calls, "handler")
+		return Ack, nil
+	}, []Middleware{named("outer"), named("inner")})
+
+	if _, err := h(context.Background(), Delivery{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(calls, " "); got != "outer inner handler" {
+		t.Errorf("ran %q, want outer inner handler", got)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := Recover()(func(context.Context, Delivery, any) (AckType, error) {
+		panic("no such unit")
+	})
+	acktype, err := h(context.Background(), Delivery{}, nil)
+	if acktype != NackDiscard || !errors.Is(err, ErrPermanent) {
+		t.Errorf("got %v, %v, want a permanent failure", acktype, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "no such unit") {
+		t.Errorf("got error %v, want it to carry the panic", err)
+	}
+}
+
+func TestSubscribeRecoverDeadLettersPanics(t *testing.T) {
+	b := newTestBroker(t)
+	subscribeMoves(t, b, func(context.Context, testMove) (AckType, error) {
+		panic("no such unit")
+	}, WithMiddleware(Recover()), WithRetry(testRetry))
+
+	if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	dl := deadLetters(t, b, 1)[0]
+	if !strings.Contains(dl.Reason, "panic: no such unit") {
+		t.Errorf("got reason %q", dl.Reason)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	slow := func(ctx context.Context, _ Delivery, _ any) (AckType, error) {
+		<-ctx.Done()
+		return Ack, nil
+	}
+	acktype, err := Timeout(10*time.Millisecond)(slow)(context.Background(), Delivery{}, nil)
+	if acktype != NackRetry || !errors.Is(err, ErrRetry) {
+		t.Errorf("got %v, %v from a handler that ran out of time, want a retry", acktype, err)
+	}
+
+	fast := func(context.Context, Delivery, any) (AckType, error) {
+		return NackDiscard, nil
+	}
+	acktype, err = Timeout(testTimeout)(fast)(context.Background(), Delivery{}, nil)
+	if acktype != NackDiscard || err != nil {
+		t.Errorf("got %v, %v, want the handler's own result", acktype, err)
+	}
+}
+
+func TestTimeoutPassesPanicsToRecover(t *testing.T) {
+	h := Recover()(Timeout(testTimeout)(func(context.Context, Delivery, any) (AckType, error) {
+		panic("no such unit")
+	}))
+	acktype, err := h(context.Background(), Delivery{}, nil)
+	if acktype != NackDiscard || !errors.Is(err, ErrPermanent) {
+		t.Errorf("got %v, %v, want the panic recovered", acktype, err)
+	}
+}
@@ -0,0 +1,20 @@
+package pubsub
+
+import "github.com/vmihailenco/msgpack/v5"
+
+// MsgPack encodes values as MessagePack, using the same field names as Go.
+var MsgPack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
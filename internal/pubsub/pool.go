@@ -0,0 +1,81 @@
+package pubsub
+
+import (
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// maxIdleChannels bounds how many publishing channels each pool keeps open
+// between publishes.
+const maxIdleChannels = 8
+
+// pooledChannel is a publishing channel checked out of a channelPool. Only
+// the goroutine holding it may publish on it, as amqp channels are not safe
+// for concurrent publishing.
+type pooledChannel struct {
+	*amqp.Channel
+	conn *amqp.Connection
+	// returns is set on confirm channels. It is drained by every publish, so
+	// a returned message is never reported to the next one.
+	returns chan amqp.Return
+}
+
+// channelPool reuses channels for publishing instead of opening one per
+// message. Channels from an earlier connection, or closed by the broker
+// after a channel error, are thrown away when they are next taken.
+type channelPool struct {
+	confirm bool
+
+	mu   sync.Mutex
+	idle []*pooledChannel
+}
+
+func (p *channelPool) get(conn *amqp.Connection) (*pooledChannel, error) {
+	var stale []*pooledChannel
+	var ch *pooledChannel
+	p.mu.Lock()
+	for len(p.idle) > 0 && ch == nil {
+		last := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		if last.conn == conn && !last.IsClosed() {
+			ch = last
+		} else {
+			stale = append(stale, last)
+		}
+	}
+	p.mu.Unlock()
+	for _, s := range stale {
+		s.Close()
+	}
+	if ch != nil {
+		return ch, nil
+	}
+
+	amqpCh, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	ch = &pooledChannel{Channel: amqpCh, conn: conn}
+	if p.confirm {
+		if err := amqpCh.Confirm(false); err != nil {
+			amqpCh.Close()
+			return nil, err
+		}
+		ch.returns = amqpCh.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	return ch, nil
+}
+
+// put gives ch back to the pool once its publish has finished cleanly.
+func (p *channelPool) put(ch *pooledChannel) {
+	p.mu.Lock()
+	if len(p.idle) < maxIdleChannels {
+		p.idle = append(p.idle, ch)
+		ch = nil
+	}
+	p.mu.Unlock()
+	if ch != nil {
+		ch.Close()
+	}
+}
@@ -0,0 +1,46 @@
+package pubsub
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf encodes generated protocol buffer messages, and other types that
+// convert themselves with ProtoMarshaler and ProtoUnmarshaler.
+var Protobuf Codec = protobufCodec{}
+
+// ProtoMarshaler is implemented by types with a protocol buffer wire form.
+type ProtoMarshaler interface {
+	MarshalProto() ([]byte, error)
+}
+
+type ProtoUnmarshaler interface {
+	UnmarshalProto(data []byte) error
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case proto.Message:
+		return proto.Marshal(v)
+	case ProtoMarshaler:
+		return v.MarshalProto()
+	}
+	return nil, fmt.Errorf("cannot encode %T as protobuf", v)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, v)
+	case ProtoUnmarshaler:
+		return v.UnmarshalProto(data)
+	}
+	return fmt.Errorf("cannot decode protobuf into %T", v)
+}
@@ -0,0 +1,13 @@
+package pubsub
+
+import "testing"
+
+func TestProtobufRejectsPlainTypes(t *testing.T) {
+	if _, err := Protobuf.Marshal(testMove{Player: "alice"}); err == nil {
+		t.Error("encoded a type without a protobuf form")
+	}
+	var mv testMove
+	if err := Protobuf.Unmarshal(nil, &mv); err == nil {
+		t.Error("decoded into a type without a protobuf form")
+	}
+}
@@ -1,159 +1,204 @@
 package pubsub
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/json"
-	"log"
-
-	amqp "github.com/rabbitmq/amqp091-go"
+	"context"
+	"crypto/ed25519"
+	"time"
 )
 
-func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	val_json, err := json.Marshal(val)
-	if err != nil {
-		return err
-	}
-	return ch.Publish(exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/json",
-		Body:        val_json,
-	})
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	ctx                  context.Context
+	confirm              bool
+	confirmTimeout       time.Duration
+	compressor           Compressor
+	compressionThreshold int
+	signer               string
+	signingKey           ed25519.PrivateKey
 }
 
-type SimpleQueueType int
+// WithConfirm makes the publish mandatory and waits up to timeout for the
+// broker to confirm it. See Broker.PublishConfirm for the errors returned.
+func WithConfirm(timeout time.Duration) PublishOption {
+	return func(o *publishOptions) {
+		o.confirm = true
+		o.confirmTimeout = timeout
+	}
+}
 
-const (
-	Durable SimpleQueueType = iota
-	Transient
-)
+func newPublishOptions(opts []PublishOption) publishOptions {
+	o := publishOptions{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
 
-func DeclareAndBind(
-	conn *amqp.Connection,
-	exchange,
-	queueName,
-	key string,
-	simpleQueueType SimpleQueueType,
-) (*amqp.Channel, amqp.Queue, error) {
-	ch, err := conn.Channel()
+// prepare compresses and signs msg as the options ask, and records the span
+// and the delivery being handled in ctx as its publisher and cause.
+func prepare(ctx context.Context, key string, msg Message, o publishOptions) (Message, error) {
+	msg, err := compress(msg, o.compressor, o.compressionThreshold)
 	if err != nil {
-		return ch, amqp.Queue{}, err
+		return msg, err
+	}
+	headers := map[string]any{}
+	injectTrace(ctx, headers)
+	injectLineage(ctx, msg, headers)
+	if o.signer != "" {
+		sign(msg, key, o.signer, o.signingKey, headers)
 	}
-	queueArgs := amqp.Table{}
-	queueArgs["x-dead-letter-exchange"] = "peril_dlx"
-	q, err := ch.QueueDeclare(queueName, simpleQueueType == Durable, simpleQueueType == Transient, simpleQueueType == Transient, false, queueArgs)
+	return withHeaders(msg, headers), nil
+}
+
+func publish(b Broker, exchange, key string, msg Message, opts []PublishOption) (err error) {
+	o := newPublishOptions(opts)
+	ctx, span := startPublishSpan(o.ctx, exchange, key, msg)
+	defer func() { span.end(err) }()
+	msg, err = prepare(ctx, key, msg, o)
 	if err != nil {
-		return ch, q, err
+		return err
+	}
+	if !o.confirm {
+		return b.Publish(exchange, key, msg)
 	}
-	err = ch.QueueBind(queueName, key, exchange, false, nil)
+	ctx, cancel := context.WithTimeout(ctx, o.confirmTimeout)
+	defer cancel()
+	return b.PublishConfirm(ctx, exchange, key, msg)
+}
+
+// Publish encodes val with codec and publishes it with the codec's content
+// type and a new envelope. The type and schema version are set if T was
+// registered with RegisterType.
+func Publish[T any](b Broker, exchange, key string, val T, codec Codec, opts ...PublishOption) error {
+	body, err := codec.Marshal(val)
 	if err != nil {
-		return ch, q, err
+		return err
+	}
+	msg := Message{
+		ContentType: codec.ContentType(),
+		Body:        body,
 	}
-	return ch, q, err
+	envelope[T](&msg)
+	return publish(b, exchange, key, msg, opts)
+}
+
+func PublishJSON[T any](b Broker, exchange, key string, val T, opts ...PublishOption) error {
+	return Publish(b, exchange, key, val, JSON, opts...)
 }
 
+type SimpleQueueType int
+
+const (
+	Durable SimpleQueueType = iota
+	Transient
+)
+
 type AckType int
 
 const (
 	Ack AckType = iota
 	NackRequeue
 	NackDiscard
+	// NackRetry redelivers the message after a backoff delay, up to the
+	// subscription's RetryPolicy, and then dead-letters it.
+	NackRetry
 )
 
-func subscribe[T any](
-	conn *amqp.Connection,
+// Subscribe decodes each delivery with the codec registered for its content
+// type, so one queue can carry several formats. Deliveries without a content
+// type use the codec given by WithDefaultCodec. Deliveries that cannot be
+// decoded are dead-lettered.
+func Subscribe[T any](
+	ctx context.Context,
+	b Broker,
 	exchange,
 	queueName,
 	key string,
 	simpleQueueType SimpleQueueType,
 	handler func(T) AckType,
-	unmarshaller func([]byte) (T, error),
-) error {
-	DeclareAndBind(conn, exchange, queueName, key, simpleQueueType)
-	ch, err := conn.Channel()
-	if err != nil {
-		return err
-	}
-	err = ch.Qos(
-		10,    // prefetchCount: only 1 unacked message at a time
-		0,     // prefetchSize: not used
-		false, // global
-	)
-	if err != nil {
-		log.Printf("could not set QoS: %v", err)
-		return err
-	}
-	msgs, err := ch.Consume(queueName, "", false, false, false, false, nil)
-	if err != nil {
-		return err
-	}
-	go func() {
-		for msg := range msgs {
-			val, err := unmarshaller(msg.Body)
-			if err != nil {
-				log.Printf("Failed to unmarshal message: %v", err)
-				msg.Ack(false)
-				continue
-			}
-			acktype := handler(val)
-			switch acktype {
-			case Ack:
-				msg.Ack(false)
-			case NackRequeue:
-				msg.Nack(false, true)
-			case NackDiscard:
-				msg.Nack(false, false)
-			}
-		}
-	}()
-	return nil
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	return subscribe[T](ctx, b, exchange, queueName, key, simpleQueueType, valueOnly(handler), opts)
 }
 
 func SubscribeJSON[T any](
-	conn *amqp.Connection,
+	ctx context.Context,
+	b Broker,
 	exchange,
 	queueName,
 	key string,
 	simpleQueueType SimpleQueueType,
 	handler func(T) AckType,
-) error {
-	unmarshaller := func(body []byte) (T, error) {
-		var val T
-		err := json.Unmarshal(body, &val)
-		return val, err
-	}
-	return subscribe(conn, exchange, queueName, key, simpleQueueType, handler, unmarshaller)
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	opts = append([]SubscribeOption{WithDefaultCodec(JSON)}, opts...)
+	return subscribe[T](ctx, b, exchange, queueName, key, simpleQueueType, valueOnly(handler), opts)
 }
 
 func SubscribeGob[T any](
-	conn *amqp.Connection,
+	ctx context.Context,
+	b Broker,
 	exchange,
 	queueName,
 	key string,
 	simpleQueueType SimpleQueueType,
 	handler func(T) AckType,
-) error {
-	unmarshaller := func(body []byte) (T, error) {
-		var val T
-		buf := bytes.NewBuffer(body)
-		dec := gob.NewDecoder(buf)
-		err := dec.Decode(&val)
-		return val, err
-	}
-	return subscribe(conn, exchange, queueName, key, simpleQueueType, handler, unmarshaller)
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	opts = append([]SubscribeOption{WithDefaultCodec(Gob)}, opts...)
+	return subscribe[T](ctx, b, exchange, queueName, key, simpleQueueType, valueOnly(handler), opts)
 }
 
-func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
+func PublishGob[T any](b Broker, exchange, key string, val T, opts ...PublishOption) error {
+	return Publish(b, exchange, key, val, Gob, opts...)
+}
 
-	err := enc.Encode(val)
-	if err != nil {
-		return err
-	}
-	val_gob := buf.Bytes()
+// SubscribeErr is Subscribe for handlers that report failures as errors.
+// Errors are logged, and a retried or dead-lettered message carries the
+// error in its headers. An error wrapping ErrPermanent dead-letters the
+// message and one wrapping ErrRetry retries it; any other error is settled
+// as the returned AckType, with Ack taken to mean NackRetry.
+//
+// ctx carries the trace of the delivery; publish WithContext(ctx) so the
+// messages the handler publishes continue it.
+func SubscribeErr[T any](
+	ctx context.Context,
+	b Broker,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+	handler func(context.Context, T) (AckType, error),
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	return subscribe[T](ctx, b, exchange, queueName, key, simpleQueueType, valueOnlyErr(handler), opts)
+}
+
+func SubscribeJSONErr[T any](
+	ctx context.Context,
+	b Broker,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+	handler func(context.Context, T) (AckType, error),
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	opts = append([]SubscribeOption{WithDefaultCodec(JSON)}, opts...)
+	return subscribe[T](ctx, b, exchange, queueName, key, simpleQueueType, valueOnlyErr(handler), opts)
+}
 
-	return ch.Publish(exchange, key, false, false, amqp.Publishing{
-		ContentType: "application/gob",
-		Body:        val_gob,
-	})
+func SubscribeGobErr[T any](
+	ctx context.Context,
+	b Broker,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+	handler func(context.Context, T) (AckType, error),
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	opts = append([]SubscribeOption{WithDefaultCodec(Gob)}, opts...)
+	return subscribe[T](ctx, b, exchange, queueName, key, simpleQueueType, valueOnlyErr(handler), opts)
 }
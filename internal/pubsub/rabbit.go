@@ -0,0 +1,692 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	reconnectMinDelay = 500 * time.Millisecond
+	reconnectMaxDelay = 30 * time.Second
+)
+
+var ErrNotConnected = errors.New("not connected to broker")
+
+// RabbitBroker is a Broker backed by a managed RabbitMQ connection. When the
+// connection drops it redials with backoff, re-declares everything declared
+// through it and resumes every consumer on the same delivery channel.
+type RabbitBroker struct {
+	url string
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	conn     *amqp.Connection
+	topology Topology
+	closed   bool
+
+	// getCh is shared by every Get so that fetched messages stay
+	// unacknowledged until they are settled.
+	getMu sync.Mutex
+	getCh *amqp.Channel
+
+	publishPool channelPool
+	confirmPool channelPool
+}
+
+var _ Broker = (*RabbitBroker)(nil)
+
+func DialRabbit(url string) (*RabbitBroker, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	b := &RabbitBroker{url: url, conn: conn, confirmPool: channelPool{confirm: true}}
+	b.cond = sync.NewCond(&b.mu)
+	go b.watch(conn)
+	return b, nil
+}
+
+// watch waits for conn to close and, unless the broker was closed on
+// purpose, replaces it with a fresh connection.
+func (b *RabbitBroker) watch(conn *amqp.Connection) {
+	for {
+		amqpErr := <-conn.NotifyClose(make(chan *amqp.Error, 1))
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		b.conn = nil
+		b.mu.Unlock()
+		log.Printf("connection to broker lost: %v", amqpErr)
+
+		conn = b.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+func (b *RabbitBroker) reconnect() *amqp.Connection {
+	delay := reconnectMinDelay
+	for {
+		time.Sleep(delay)
+		delay = min(delay*2, reconnectMaxDelay)
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return nil
+		}
+		var topology Topology
+		topology.merge(b.topology)
+		b.mu.Unlock()
+
+		conn, err := amqp.Dial(b.url)
+		if err != nil {
+			log.Printf("could not reconnect to broker: %v", err)
+			continue
+		}
+		if err := declareOn(conn, topology); err != nil {
+			log.Printf("could not re-declare topology: %v", err)
+			conn.Close()
+			continue
+		}
+
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		b.conn = conn
+		b.cond.Broadcast()
+		b.mu.Unlock()
+		log.Printf("reconnected to broker")
+		return conn
+	}
+}
+
+// connection returns the current connection, or ErrNotConnected while a
+// reconnect is in progress.
+func (b *RabbitBroker) connection() (*amqp.Connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+	if b.conn == nil || b.conn.IsClosed() {
+		return nil, ErrNotConnected
+	}
+	return b.conn, nil
+}
+
+// waitConnection blocks until a connection is available. It returns nil once
+// the broker has been closed or ctx is done; callers waking on ctx must
+// broadcast b.cond themselves.
+func (b *RabbitBroker) waitConnection(ctx context.Context) *amqp.Connection {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for !b.closed && ctx.Err() == nil && b.conn == nil {
+		b.cond.Wait()
+	}
+	if b.closed || ctx.Err() != nil {
+		return nil
+	}
+	return b.conn
+}
+
+func (b *RabbitBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBrokerClosed
+	}
+	b.closed = true
+	conn := b.conn
+	b.cond.Broadcast()
+	b.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+func (b *RabbitBroker) Publish(exchange, key string, msg Message) error {
+	err := b.withChannel(&b.publishPool, func(ch *pooledChannel) error {
+		return ch.Publish(exchange, key, false, false, toPublishing(msg))
+	})
+	recordPublish(exchange, err)
+	return err
+}
+
+func (b *RabbitBroker) PublishConfirm(ctx context.Context, exchange, key string, msg Message) error {
+	err := b.withChannel(&b.confirmPool, func(ch *pooledChannel) error {
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, toPublishing(msg))
+		if err != nil {
+			return err
+		}
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		// RabbitMQ sends basic.return before the ack for an unroutable
+		// message, so by now it is already buffered.
+		select {
+		case ret := <-ch.returns:
+			return &ReturnError{
+				Exchange:  ret.Exchange,
+				Key:       ret.RoutingKey,
+				ReplyCode: int(ret.ReplyCode),
+				ReplyText: ret.ReplyText,
+			}
+		default:
+		}
+		if !acked {
+			return ErrNacked
+		}
+		return nil
+	})
+	recordPublish(exchange, err)
+	return err
+}
+
+func (b *RabbitBroker) PublishBatch(ctx context.Context, batch []BatchMessage) error {
+	conn, err := b.connection()
+	if err == nil {
+		var ch *pooledChannel
+		ch, err = b.confirmPool.get(conn)
+		if err == nil {
+			return b.publishBatch(ctx, ch, batch)
+		}
+	}
+	for _, m := range batch {
+		recordPublish(m.Exchange, err)
+	}
+	return err
+}
+
+func (b *RabbitBroker) publishBatch(ctx context.Context, ch *pooledChannel, batch []BatchMessage) error {
+
+	// The channel stalls until each return is read, so they are collected
+	// while the batch is in flight rather than after it.
+	var returns []amqp.Return
+	stop := make(chan struct{})
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for {
+			select {
+			case ret := <-ch.returns:
+				returns = append(returns, ret)
+			case <-stop:
+				for {
+					select {
+					case ret := <-ch.returns:
+						returns = append(returns, ret)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	errs := make([]error, len(batch))
+	confirmations := make([]*amqp.DeferredConfirmation, len(batch))
+	clean := true
+	for i, m := range batch {
+		confirmations[i], errs[i] = ch.PublishWithDeferredConfirmWithContext(ctx, m.Exchange, m.Key, true, false, toPublishing(m.Message))
+		if errs[i] != nil {
+			clean = false
+		}
+	}
+	for i, confirmation := range confirmations {
+		if confirmation == nil {
+			continue
+		}
+		acked, err := confirmation.WaitContext(ctx)
+		if err != nil {
+			errs[i] = err
+			clean = false
+		} else if !acked {
+			errs[i] = ErrNacked
+		}
+	}
+	close(stop)
+	<-collected
+
+	returned := make([]bool, len(batch))
+	for _, ret := range returns {
+		if i := returnedIndex(batch, returned, ret); i >= 0 {
+			returned[i] = true
+			errs[i] = &ReturnError{
+				Exchange:  ret.Exchange,
+				Key:       ret.RoutingKey,
+				ReplyCode: int(ret.ReplyCode),
+				ReplyText: ret.ReplyText,
+			}
+		}
+	}
+	if clean {
+		b.confirmPool.put(ch)
+	} else {
+		ch.Close()
+	}
+	for i, m := range batch {
+		recordPublish(m.Exchange, errs[i])
+	}
+	return batchResult(errs)
+}
+
+// returnedIndex finds the message of batch that ret returned: the one with
+// its message ID, or else the first not yet returned with its exchange and
+// routing key.
+func returnedIndex(batch []BatchMessage, returned []bool, ret amqp.Return) int {
+	for i, m := range batch {
+		if returned[i] {
+			continue
+		}
+		if ret.MessageId != "" {
+			if m.Message.MessageID == ret.MessageId {
+				return i
+			}
+			continue
+		}
+		if m.Exchange == ret.Exchange && m.Key == ret.RoutingKey {
+			return i
+		}
+	}
+	return -1
+}
+
+// withChannel runs publish on a channel from pool. A channel that the
+// broker closed after an earlier channel error fails with amqp.ErrClosed,
+// and publish is then tried once more on a fresh one. Channels are only
+// reused after publishes that left them in a known state.
+func (b *RabbitBroker) withChannel(pool *channelPool, publish func(ch *pooledChannel) error) error {
+	for attempt := 0; ; attempt++ {
+		conn, err := b.connection()
+		if err != nil {
+			return err
+		}
+		ch, err := pool.get(conn)
+		if err != nil {
+			return err
+		}
+		err = publish(ch)
+		var returned *ReturnError
+		if err == nil || errors.Is(err, ErrNacked) || errors.As(err, &returned) {
+			pool.put(ch)
+			return err
+		}
+		// The channel may still be waiting on a confirm or return for this
+		// publish, which would be mistaken for the next one's.
+		ch.Close()
+		if !errors.Is(err, amqp.ErrClosed) || attempt > 0 {
+			return err
+		}
+	}
+}
+
+func (b *RabbitBroker) DeclareExchange(name, kind string) error {
+	return b.declare(Topology{Exchanges: []Exchange{{Name: name, Kind: kind}}})
+}
+
+func (b *RabbitBroker) DeclareAndBind(
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+) error {
+	return b.declare(Topology{
+		Queues:   []Queue{{Name: queueName, Options: queueOptions(simpleQueueType)}},
+		Bindings: []Binding{{Queue: queueName, Key: key, Exchange: exchange}},
+	})
+}
+
+func (b *RabbitBroker) DeclareQueue(queueName string, opts QueueOptions) error {
+	return b.declare(Topology{Queues: []Queue{{Name: queueName, Options: opts}}})
+}
+
+func (b *RabbitBroker) BindQueue(queueName, key, exchange string) error {
+	return b.declare(Topology{Bindings: []Binding{{Queue: queueName, Key: key, Exchange: exchange}}})
+}
+
+// declare applies t and remembers it so it is re-declared after a reconnect.
+func (b *RabbitBroker) declare(t Topology) error {
+	conn, err := b.connection()
+	if err != nil {
+		return err
+	}
+	if err := declareOn(conn, t); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.topology.merge(t)
+	return nil
+}
+
+func declareOn(conn *amqp.Connection, t Topology) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	for _, ex := range t.Exchanges {
+		if err := ch.ExchangeDeclare(ex.Name, ex.Kind, true, false, false, false, nil); err != nil {
+			return err
+		}
+	}
+	for _, q := range t.Queues {
+		_, err := ch.QueueDeclare(q.Name, q.Options.Durable, q.Options.AutoDelete, q.Options.Exclusive, false, queueArgs(q.Options))
+		if err != nil {
+			return err
+		}
+	}
+	for _, binding := range t.Bindings {
+		if err := ch.QueueBind(binding.Queue, binding.Key, binding.Exchange, false, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queueArgs(opts QueueOptions) amqp.Table {
+	args := amqp.Table{}
+	if opts.DeadLetter {
+		args["x-dead-letter-exchange"] = opts.DeadLetterExchange
+		if opts.DeadLetterRoutingKey != "" {
+			args["x-dead-letter-routing-key"] = opts.DeadLetterRoutingKey
+		}
+	}
+	if opts.MessageTTL > 0 {
+		args["x-message-ttl"] = opts.MessageTTL.Milliseconds()
+	}
+	if opts.Expires > 0 {
+		args["x-expires"] = opts.Expires.Milliseconds()
+	}
+	return args
+}
+
+func (b *RabbitBroker) Get(queueName string) (Delivery, bool, error) {
+	b.getMu.Lock()
+	defer b.getMu.Unlock()
+	if b.getCh == nil || b.getCh.IsClosed() {
+		conn, err := b.connection()
+		if err != nil {
+			return Delivery{}, false, err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			return Delivery{}, false, err
+		}
+		b.getCh = ch
+	}
+	msg, ok, err := b.getCh.Get(queueName, false)
+	if err != nil || !ok {
+		return Delivery{}, false, err
+	}
+	return fromAMQP(msg, func() {}), true, nil
+}
+
+func (b *RabbitBroker) PurgeQueue(queueName string) (int, error) {
+	conn, err := b.connection()
+	if err != nil {
+		return 0, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+	return ch.QueuePurge(queueName, false)
+}
+
+// Consume returns a delivery channel that survives reconnects. When ctx is
+// done the consumer is cancelled and the channel closed; the AMQP channel
+// itself stays open until every delivery already handed out is settled.
+func (b *RabbitBroker) Consume(ctx context.Context, queueName string, prefetch int) (<-chan Delivery, error) {
+	conn, err := b.connection()
+	if err != nil {
+		return nil, err
+	}
+	c, err := consume(conn, queueName, prefetch)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for c != nil {
+			if !c.forward(ctx, deliveries) {
+				c.stop()
+				return
+			}
+			c.ch.Close()
+			c = b.resume(ctx, queueName, prefetch)
+		}
+	}()
+	return deliveries, nil
+}
+
+// resume re-registers a consumer on queueName after its channel was closed,
+// retrying with backoff. It returns nil once ctx is done or the broker has
+// been closed.
+func (b *RabbitBroker) resume(ctx context.Context, queueName string, prefetch int) *rabbitConsumer {
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	defer stop()
+	delay := reconnectMinDelay
+	for {
+		conn := b.waitConnection(ctx)
+		if conn == nil {
+			return nil
+		}
+		c, err := consume(conn, queueName, prefetch)
+		if err == nil {
+			return c
+		}
+		log.Printf("could not resume consuming %s: %v", queueName, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+type rabbitConsumer struct {
+	ch       *amqp.Channel
+	tag      string
+	msgs     <-chan amqp.Delivery
+	inflight sync.WaitGroup
+}
+
+func consume(conn *amqp.Connection, queueName string, prefetch int) (*rabbitConsumer, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	err = ch.Qos(
+		prefetch, // prefetchCount: unacked messages the broker may push to this consumer
+		0,        // prefetchSize: not used
+		false,    // global: the limit is per consumer
+	)
+	if err != nil {
+		log.Printf("could not set QoS: %v", err)
+		ch.Close()
+		return nil, err
+	}
+	tag := queueName
+	msgs, err := ch.Consume(queueName, tag, false, false, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+	return &rabbitConsumer{ch: ch, tag: tag, msgs: msgs}, nil
+}
+
+// forward passes deliveries to out until the AMQP channel closes, which it
+// reports as true, or ctx is done.
+func (c *rabbitConsumer) forward(ctx context.Context, out chan<- Delivery) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case msg, ok := <-c.msgs:
+			if !ok {
+				return true
+			}
+			c.inflight.Add(1)
+			select {
+			case out <- fromAMQP(msg, sync.OnceFunc(c.inflight.Done)):
+			case <-ctx.Done():
+				msg.Nack(false, true)
+				c.inflight.Done()
+				return false
+			}
+		}
+	}
+}
+
+// stop cancels the consumer and closes its channel once the deliveries
+// already forwarded have been settled.
+func (c *rabbitConsumer) stop() {
+	if err := c.ch.Cancel(c.tag, false); err != nil {
+		log.Printf("could not cancel consumer %s: %v", c.tag, err)
+	}
+	for msg := range c.msgs {
+		msg.Nack(false, true)
+	}
+	go func() {
+		c.inflight.Wait()
+		c.ch.Close()
+	}()
+}
+
+type rabbitAcker struct {
+	msg     amqp.Delivery
+	settled func()
+}
+
+func (a rabbitAcker) Ack() error {
+	defer a.settled()
+	return a.msg.Ack(false)
+}
+
+func (a rabbitAcker) Nack(requeue bool) error {
+	defer a.settled()
+	return a.msg.Nack(false, requeue)
+}
+
+func toPublishing(msg Message) amqp.Publishing {
+	var expiration string
+	if msg.Expiration > 0 {
+		expiration = strconv.FormatInt(max(msg.Expiration.Milliseconds(), 1), 10)
+	}
+	return amqp.Publishing{
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		Headers:         toTable(msg.Headers),
+		Body:            msg.Body,
+		Type:            msg.Type,
+		MessageId:       msg.MessageID,
+		Timestamp:       msg.Timestamp,
+		AppId:           msg.Producer,
+		ReplyTo:         msg.ReplyTo,
+		CorrelationId:   msg.CorrelationID,
+		Expiration:      expiration,
+	}
+}
+
+func fromAMQP(msg amqp.Delivery, settled func()) Delivery {
+	ms, _ := strconv.ParseInt(msg.Expiration, 10, 64)
+	return Delivery{
+		Message: Message{
+			ContentType:     msg.ContentType,
+			ContentEncoding: msg.ContentEncoding,
+			Headers:         fromTable(msg.Headers),
+			Body:            msg.Body,
+			Type:            msg.Type,
+			MessageID:       msg.MessageId,
+			Timestamp:       msg.Timestamp,
+			Producer:        msg.AppId,
+			ReplyTo:         msg.ReplyTo,
+			CorrelationID:   msg.CorrelationId,
+			Expiration:      time.Duration(ms) * time.Millisecond,
+		},
+		Exchange:     msg.Exchange,
+		RoutingKey:   msg.RoutingKey,
+		Redelivered:  msg.Redelivered,
+		Acknowledger: rabbitAcker{msg: msg, settled: settled},
+	}
+}
+
+// toTable converts headers for amqp091, which only accepts nested tables of
+// its own Table type.
+func toTable(headers map[string]any) amqp.Table {
+	if headers == nil {
+		return nil
+	}
+	table := amqp.Table{}
+	for k, v := range headers {
+		table[k] = toTableValue(v)
+	}
+	return table
+}
+
+func toTableValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		return toTable(v)
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = toTableValue(item)
+		}
+		return values
+	default:
+		return v
+	}
+}
+
+// fromTable is the inverse of toTable, so code reading headers never has to
+// know about amqp.Table.
+func fromTable(table amqp.Table) map[string]any {
+	if table == nil {
+		return nil
+	}
+	headers := make(map[string]any, len(table))
+	for k, v := range table {
+		headers[k] = fromTableValue(v)
+	}
+	return headers
+}
+
+func fromTableValue(v any) any {
+	switch v := v.(type) {
+	case amqp.Table:
+		return fromTable(v)
+	case []any:
+		values := make([]any, len(v))
+		for i, item := range v {
+			values[i] = fromTableValue(item)
+		}
+		return values
+	default:
+		return v
+	}
+}
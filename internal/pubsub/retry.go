@@ -0,0 +1,203 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	attemptsHeader           = "x-attempts"
+	lastErrorHeader          = "x-last-error"
+	failureReasonHeader      = "x-failure-reason"
+	failedQueueHeader        = "x-failed-queue"
+	originalExchangeHeader   = "x-original-exchange"
+	originalRoutingKeyHeader = "x-original-routing-key"
+)
+
+const retryPublishTimeout = 5 * time.Second
+
+// RetryPolicy controls what happens to deliveries whose handler returns
+// NackRetry. Each failed attempt is parked in a delay queue for an
+// exponentially growing delay before it is redelivered; once MaxAttempts
+// have failed the message goes to DeadLetterExchange instead.
+type RetryPolicy struct {
+	MaxAttempts        int
+	InitialDelay       time.Duration
+	MaxDelay           time.Duration
+	DeadLetterExchange string
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:        5,
+	InitialDelay:       time.Second,
+	MaxDelay:           30 * time.Second,
+	DeadLetterExchange: DefaultDeadLetterExchange,
+}
+
+// WithRetry replaces DefaultRetryPolicy for the subscription.
+func WithRetry(policy RetryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = policy
+	}
+}
+
+func (p RetryPolicy) delay(attempts int) time.Duration {
+	delay := p.InitialDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// settler settles the deliveries of one subscription.
+type settler struct {
+	b         Broker
+	queueName string
+	durable   bool
+	retry     RetryPolicy
+
+	mu          sync.Mutex
+	delayQueues map[time.Duration]time.Time
+}
+
+// delayQueueGrace is how long a delay queue outlives its delay once nothing
+// is published to it, so abandoned ones are deleted by the broker.
+const delayQueueGrace = time.Minute
+
+// settle settles msg as acktype. err is the handler's error, if any; it is
+// logged and travels with the message when it is retried or dead-lettered.
+func (s *settler) settle(msg Delivery, acktype AckType, err error) {
+	if err != nil {
+		log.Printf("Handler for %s failed: %v", s.queueName, err)
+	}
+	switch acktype {
+	case Ack:
+		ackedTotal.inc(s.queueName)
+		msg.Ack()
+	case NackRequeue:
+		nackedRequeueTotal.inc(s.queueName)
+		msg.Nack(true)
+	case NackDiscard:
+		nackedDiscardTotal.inc(s.queueName)
+		// Rejecting would dead-letter the message without the error, so it
+		// is published to the dead-letter exchange here instead.
+		if err != nil && s.retry.DeadLetterExchange != "" {
+			dlErr := s.deadLetter(annotate(msg), err.Error())
+			if dlErr == nil {
+				msg.Ack()
+				return
+			}
+			log.Printf("could not dead-letter message, rejecting: %v", dlErr)
+		}
+		msg.Nack(false)
+	case NackRetry:
+		if err := s.retryLater(msg, err); err != nil {
+			log.Printf("could not schedule retry, requeueing: %v", err)
+			nackedRequeueTotal.inc(s.queueName)
+			msg.Nack(true)
+			return
+		}
+		retriedTotal.inc(s.queueName)
+		msg.Ack()
+	}
+}
+
+// annotate copies msg for republishing and records where it was first
+// published.
+func annotate(msg Delivery) Message {
+	origin := map[string]any{}
+	if _, ok := msg.Headers[originalExchangeHeader]; !ok {
+		origin[originalExchangeHeader] = msg.Exchange
+		origin[originalRoutingKeyHeader] = msg.RoutingKey
+	}
+	out := withHeaders(msg.Message, origin)
+	out.Expiration = 0
+	return out
+}
+
+// retryLater republishes msg to a delay queue that dead-letters back to the
+// subscription's queue, or to the dead-letter exchange once it has run out of
+// attempts. cause is the error of the failed attempt, if there was one.
+func (s *settler) retryLater(msg Delivery, cause error) error {
+	out := annotate(msg)
+	attempts := headerInt(out.Headers, attemptsHeader) + 1
+	out.Headers[attemptsHeader] = int64(attempts)
+	if cause != nil {
+		out.Headers[lastErrorHeader] = cause.Error()
+	} else {
+		delete(out.Headers, lastErrorHeader)
+	}
+
+	if attempts >= s.retry.MaxAttempts {
+		reason := fmt.Sprintf("gave up after %d attempts", attempts)
+		if cause != nil {
+			reason += ": " + cause.Error()
+		}
+		return s.deadLetter(out, reason)
+	}
+	queue, err := s.delayQueue(s.retry.delay(attempts))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), retryPublishTimeout)
+	defer cancel()
+	return s.b.PublishConfirm(ctx, "", queue, out)
+}
+
+// deadLetter publishes an annotated message to the dead-letter exchange
+// with the reason it failed.
+func (s *settler) deadLetter(out Message, reason string) error {
+	out.Headers[failureReasonHeader] = reason
+	out.Headers[failedQueueHeader] = s.queueName
+	key, _ := out.Headers[originalRoutingKeyHeader].(string)
+	ctx, cancel := context.WithTimeout(context.Background(), retryPublishTimeout)
+	defer cancel()
+	return s.b.PublishConfirm(ctx, s.retry.DeadLetterExchange, key, out)
+}
+
+// delayQueue declares the delay queue for delay, as durable as the
+// subscription's queue. Publishing does not keep a queue from expiring, so
+// it is redeclared often enough that it lives for at least delay after
+// every retry published to it.
+func (s *settler) delayQueue(delay time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := fmt.Sprintf("%s.retry.%d", s.queueName, delay.Milliseconds())
+	if declared, ok := s.delayQueues[delay]; ok && time.Since(declared) < delayQueueGrace/2 {
+		return queue, nil
+	}
+	err := s.b.DeclareQueue(queue, QueueOptions{
+		Durable:              s.durable,
+		DeadLetter:           true,
+		DeadLetterExchange:   "",
+		DeadLetterRoutingKey: s.queueName,
+		MessageTTL:           delay,
+		Expires:              delay + delayQueueGrace,
+	})
+	if err != nil {
+		return "", err
+	}
+	if s.delayQueues == nil {
+		s.delayQueues = map[time.Duration]time.Time{}
+	}
+	s.delayQueues[delay] = time.Now()
+	return queue, nil
+}
+
+func headerInt(headers map[string]any, key string) int {
+	switch v := headers[key].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,116 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRetry retries quickly so the tests do not wait on DefaultRetryPolicy.
+var testRetry = RetryPolicy{
+	MaxAttempts:        3,
+	InitialDelay:       10 * time.Millisecond,
+	MaxDelay:           15 * time.Millisecond,
+	DeadLetterExchange: DefaultDeadLetterExchange,
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempts, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+		9: 5 * time.Second,
+	} {
+		if got := policy.delay(attempts); got != want {
+			t.Errorf("delay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestSubscribeRetriesUntilHandled(t *testing.T) {
+	b := newTestBroker(t)
+	var mu sync.Mutex
+	attempts := 0
+	handled := make(chan struct{})
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 2 {
+			return NackRetry, errors.New("not yet")
+		}
+		close(handled)
+		return Ack, nil
+	}, WithRetry(testRetry))
+
+	if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(testTimeout):
+		t.Fatal("retried move was never handled")
+	}
+	if n := len(deadLetters(t, b, 0)); n != 0 {
+		t.Errorf("got %d dead letters, want none", n)
+	}
+}
+
+func TestSubscribeDeadLettersAfterMaxAttempts(t *testing.T) {
+	b := newTestBroker(t)
+	var mu sync.Mutex
+	attempts := 0
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return NackRetry, errors.New("no war to fight")
+	}, WithRetry(testRetry))
+
+	if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	dl := deadLetters(t, b, 1)[0]
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != testRetry.MaxAttempts {
+		t.Errorf("handled %d times, want %d", attempts, testRetry.MaxAttempts)
+	}
+	if dl.Queue != "moves" {
+		t.Errorf("got failed queue %q, want moves", dl.Queue)
+	}
+	if !strings.Contains(dl.Reason, "gave up after 3 attempts: no war to fight") {
+		t.Errorf("got reason %q", dl.Reason)
+	}
+	if dl.OriginalExchange != testExchange || dl.OriginalRoutingKey != "army_moves.alice" {
+		t.Errorf("got origin %s/%s, want %s/army_moves.alice", dl.OriginalExchange, dl.OriginalRoutingKey, testExchange)
+	}
+}
+
+func TestDelayQueueFollowsSubscription(t *testing.T) {
+	for _, durable := range []bool{true, false} {
+		b := newTestBroker(t)
+		s := &settler{b: b, queueName: "moves", durable: durable, retry: testRetry}
+		name, err := s.delayQueue(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.mu.Lock()
+		opts := b.queues[name].opts
+		b.mu.Unlock()
+		if opts.Durable != durable {
+			t.Errorf("delay queue of durable=%v queue has durable=%v", durable, opts.Durable)
+		}
+		if opts.Expires != time.Second+delayQueueGrace {
+			t.Errorf("got delay queue expiry %v, want %v", opts.Expires, time.Second+delayQueueGrace)
+		}
+		if opts.DeadLetterRoutingKey != "moves" {
+			t.Errorf("delay queue dead-letters to %q, want moves", opts.DeadLetterRoutingKey)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const rpcErrorHeader = "x-rpc-error"
+
+// RemoteError is an error returned by the handler serving a Call.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return "remote error: " + e.Message
+}
+
+// Caller makes requests with Call and routes the replies back to them
+// through its own exclusive reply queue.
+type Caller struct {
+	b          Broker
+	replyQueue string
+	keys       Keyring
+	claimed    KeyFunc
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	mu      sync.Mutex
+	pending map[string]chan Delivery
+}
+
+// NewCaller declares a reply queue and starts consuming it until ctx is
+// done or Close is called. Of opts only WithVerification applies: Call then
+// ignores replies that fail verification and waits for a genuine one.
+func NewCaller(ctx context.Context, b Broker, opts ...SubscribeOption) (*Caller, error) {
+	o := newSubscribeOptions(opts)
+	c := &Caller{
+		b:          b,
+		replyQueue: "rpc.reply." + newMessageID(),
+		keys:       o.keys,
+		claimed:    o.claimed,
+		done:       make(chan struct{}),
+		pending:    map[string]chan Delivery{},
+	}
+	err := b.DeclareQueue(c.replyQueue, QueueOptions{AutoDelete: true, Exclusive: true})
+	if err != nil {
+		return nil, err
+	}
+	ctx, c.cancel = context.WithCancel(ctx)
+	replies, err := b.Consume(ctx, c.replyQueue, defaultPrefetch)
+	if err != nil {
+		c.cancel()
+		return nil, err
+	}
+	go func() {
+		defer close(c.done)
+		for d := range replies {
+			d.Ack()
+			c.mu.Lock()
+			waiting, ok := c.pending[d.CorrelationID]
+			c.mu.Unlock()
+			// Replies to calls that have already given up, and repeated
+			// replies, are dropped.
+			if ok {
+				select {
+				case waiting <- d:
+				default:
+				}
+			}
+		}
+	}()
+	return c, nil
+}
+
+func (c *Caller) Close() {
+	c.cancel()
+	<-c.done
+}
+
+// Call publishes req to exchange with key and waits for the reply from the
+// matching Serve until ctx is done. The request is encoded with codec and
+// the reply is decoded by its content type. A request still queued when ctx
+// reaches its deadline expires.
+func Call[Req, Resp any](ctx context.Context, c *Caller, exchange, key string, req Req, codec Codec) (resp Resp, err error) {
+	body, err := codec.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+	msg := Message{
+		ContentType: codec.ContentType(),
+		Body:        body,
+		ReplyTo:     c.replyQueue,
+	}
+	envelope[Req](&msg)
+	msg.CorrelationID = msg.MessageID
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.Expiration = time.Until(deadline)
+	}
+	ctx, span := startPublishSpan(ctx, exchange, key, msg)
+	span.Name = "call " + key
+	defer func() { span.end(err) }()
+	headers := map[string]any{}
+	injectTrace(ctx, headers)
+	injectLineage(ctx, msg, headers)
+	msg = withHeaders(msg, headers)
+
+	replies := make(chan Delivery, maxPendingReplies)
+	c.mu.Lock()
+	c.pending[msg.CorrelationID] = replies
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, msg.CorrelationID)
+		c.mu.Unlock()
+	}()
+
+	// Confirming the request reports at once when nothing serves key.
+	if err := c.b.PublishConfirm(ctx, exchange, key, msg); err != nil {
+		return resp, err
+	}
+	for {
+		var reply Delivery
+		select {
+		case reply = <-replies:
+		case <-c.done:
+			return resp, fmt.Errorf("call to %s: caller closed", key)
+		case <-ctx.Done():
+			return resp, fmt.Errorf("call to %s: %w", key, ctx.Err())
+		}
+		var signer string
+		if c.keys != nil {
+			if signer, err = verify(reply, c.keys); err != nil {
+				log.Printf("Rejected reply to %s: %v", key, err)
+				continue
+			}
+		}
+		resp, err = readReply[Resp](reply)
+		if c.keys != nil {
+			var val any
+			if err == nil {
+				val = resp
+			}
+			if claimed := c.claimed(reply, val); claimed != signer {
+				log.Printf("Rejected reply to %s signed by %q on behalf of %q", key, signer, claimed)
+				continue
+			}
+		}
+		return resp, err
+	}
+}
+
+// rpcError is the error text of a reply, if it carries one.
+func rpcError(msg Message) string {
+	text, _ := msg.Headers[rpcErrorHeader].(string)
+	return text
+}
+
+// maxPendingReplies bounds the replies kept for one call while it checks
+// them; any more are dropped.
+const maxPendingReplies = 4
+
+func readReply[Resp any](reply Delivery) (Resp, error) {
+	var resp Resp
+	if text, ok := reply.Headers[rpcErrorHeader].(string); ok {
+		return resp, &RemoteError{Message: text}
+	}
+	decompressed, err := Decompress(reply.Message)
+	if err != nil {
+		return resp, err
+	}
+	codec, err := CodecFor(reply.ContentType)
+	if err != nil {
+		return resp, err
+	}
+	return unmarshal[Resp](codec, decompressed)
+}
+
+// Serve answers the requests Call sends to exchange with key, replying in
+// the format each request was made in. Errors returned by handler are
+// passed back to the caller as a *RemoteError. Replies are published with
+// the options given by WithReplyOptions. Requests that expire before they
+// are served are dropped rather than dead-lettered.
+func Serve[Req, Resp any](
+	ctx context.Context,
+	b Broker,
+	exchange,
+	queueName,
+	key string,
+	handler func(Req) (Resp, error),
+	opts ...SubscribeOption,
+) (*Subscription, error) {
+	opts = append([]SubscribeOption{WithDefaultCodec(JSON)}, opts...)
+	replyOpts := newPublishOptions(newSubscribeOptions(opts).reply)
+	if err := b.DeclareQueue(queueName, QueueOptions{Durable: true}); err != nil {
+		return nil, fmt.Errorf("could not declare queue %s: %w", queueName, err)
+	}
+	if err := b.BindQueue(queueName, key, exchange); err != nil {
+		return nil, fmt.Errorf("could not bind queue %s: %w", queueName, err)
+	}
+	return subscribeQueue[Req](ctx, b, queueName, true, func(ctx context.Context, d Delivery, val any) (AckType, error) {
+		if d.ReplyTo == "" {
+			return NackDiscard, Permanent(fmt.Errorf("request %s has no reply-to queue", d.MessageID))
+		}
+		codec, err := CodecFor(d.ContentType)
+		if err != nil {
+			codec = JSON
+		}
+		reply := Message{ContentType: codec.ContentType()}
+		envelope[Resp](&reply)
+		reply.CorrelationID = d.CorrelationID
+		resp, err := handler(val.(Req))
+		if err == nil {
+			reply.Body, err = codec.Marshal(resp)
+		}
+		if err != nil {
+			reply = withHeader(reply, rpcErrorHeader, err.Error())
+		}
+		reply, err = prepare(ctx, d.ReplyTo, reply, replyOpts)
+		if err != nil {
+			return NackDiscard, Permanent(fmt.Errorf("could not prepare reply: %w", err))
+		}
+		// A lost reply only makes the caller time out, so it is not worth
+		// redelivering the request for.
+		if err := b.Publish("", d.ReplyTo, reply); err != nil {
+			log.Printf("Failed to reply to %s: %v", d.ReplyTo, err)
+		}
+		return Ack, nil
+	}, opts)
+}
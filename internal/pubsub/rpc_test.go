@@ -0,0 +1,106 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestCaller(t *testing.T, b Broker, opts ...SubscribeOption) *Caller {
+	t.Helper()
+	c, err := NewCaller(context.Background(), b, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// serveMoves answers calls to rpc.<queueName> with the next move of the
+// same player, or with an error for moves with a negative Seq.
+func serveMoves(t *testing.T, b Broker, queueName string, opts ...SubscribeOption) *Subscription {
+	t.Helper()
+	sub, err := Serve(context.Background(), b, testExchange, queueName, "rpc."+queueName, func(mv testMove) (testMove, error) {
+		if mv.Seq < 0 {
+			return mv, errors.New("no such move")
+		}
+		return testMove{Player: mv.Player, Seq: mv.Seq + 1}, nil
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Close)
+	return sub
+}
+
+func TestCallServe(t *testing.T) {
+	b := newTestBroker(t)
+	serveMoves(t, b, "moves")
+	c := newTestCaller(t, b)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	for _, codec := range []Codec{JSON, Gob} {
+		next, err := Call[testMove, testMove](ctx, c, testExchange, "rpc.moves", testMove{Player: "alice", Seq: 1}, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != (testMove{Player: "alice", Seq: 2}) {
+			t.Errorf("got %+v over %s, want alice's move 2", next, codec.ContentType())
+		}
+	}
+
+	_, err := Call[testMove, testMove](ctx, c, testExchange, "rpc.moves", testMove{Seq: -1}, JSON)
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Message != "no such move" {
+		t.Errorf("got error %v, want the remote error", err)
+	}
+}
+
+func TestCallIgnoresUnverifiedReplies(t *testing.T) {
+	b := newTestBroker(t)
+	keys := Keys{}
+	server := newKey(t, keys, "server")
+	mallory := newKey(t, keys, "mallory")
+	byServer := func(Delivery, any) string { return "server" }
+	serveMoves(t, b, "genuine", WithReplyOptions(WithSigner("server", server)))
+	serveMoves(t, b, "forged", WithReplyOptions(WithSigner("server", mallory)))
+	serveMoves(t, b, "impostor", WithReplyOptions(WithSigner("mallory", mallory)))
+	serveMoves(t, b, "unsigned")
+	c := newTestCaller(t, b, WithVerification(keys, byServer))
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := Call[testMove, testMove](ctx, c, testExchange, "rpc.genuine", testMove{}, JSON); err != nil {
+		t.Fatalf("genuine reply was rejected: %v", err)
+	}
+	for _, key := range []string{"rpc.forged", "rpc.impostor", "rpc.unsigned"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := Call[testMove, testMove](ctx, c, testExchange, key, testMove{}, JSON)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("call to %s got error %v, want it to time out", key, err)
+		}
+	}
+}
+
+func TestCallExpiredRequestIsDropped(t *testing.T) {
+	b := newTestBroker(t)
+	// Nothing serves the queue any more, so the request expires in it.
+	serveMoves(t, b, "moves").Close()
+	c := newTestCaller(t, b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := Call[testMove, testMove](ctx, c, testExchange, "rpc.moves", testMove{}, JSON)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want the call to time out", err)
+	}
+	if _, ok, err := b.Get("moves"); err != nil || ok {
+		t.Fatalf("expired request is still queued: %v", err)
+	}
+	if n := len(deadLetters(t, b, 0)); n != 0 {
+		t.Errorf("got %d dead letters, want none", n)
+	}
+}
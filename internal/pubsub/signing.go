@@ -0,0 +1,218 @@
+package pubsub
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	signerHeader    = "x-signer"
+	signatureHeader = "x-signature"
+)
+
+var ErrBadSignature = errors.New("message signature does not match")
+
+// Keyring looks up the public key of a player. Only the player holds the
+// matching private key, so a subscriber able to verify messages cannot
+// forge them.
+type Keyring interface {
+	Key(name string) (ed25519.PublicKey, bool)
+}
+
+// Keys is a Keyring held in memory.
+type Keys map[string]ed25519.PublicKey
+
+func (k Keys) Key(name string) (ed25519.PublicKey, bool) {
+	key, ok := k[name]
+	return key, ok
+}
+
+// LoadKeys reads a JSON object mapping player names to base64 ed25519
+// public keys.
+func LoadKeys(path string) (Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys Keys
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid keys file %s: %w", path, err)
+	}
+	for name, key := range keys {
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid keys file %s: key of %s is not an ed25519 public key", path, name)
+		}
+	}
+	return keys, nil
+}
+
+// keysCheckInterval is how often a KeyFile checks its file for changes.
+const keysCheckInterval = time.Second
+
+// KeyFile is a Keyring backed by a file in the LoadKeys format. It picks up
+// changes to the file, such as a player added mid-game, without a restart.
+type KeyFile struct {
+	path string
+
+	mu      sync.Mutex
+	keys    Keys
+	modTime time.Time
+	checked time.Time
+}
+
+// OpenKeys reads the keys in path.
+func OpenKeys(path string) (*KeyFile, error) {
+	f := &KeyFile{path: path}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *KeyFile) Key(name string) (ed25519.PublicKey, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Since(f.checked) >= keysCheckInterval {
+		if err := f.reload(); err != nil {
+			log.Printf("could not reload keys: %v", err)
+		}
+	}
+	key, ok := f.keys[name]
+	return key, ok
+}
+
+// reload reads the file again if it changed since it was last read. On
+// error the keys already read stay in use.
+func (f *KeyFile) reload() error {
+	f.checked = time.Now()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if f.keys != nil && info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	keys, err := LoadKeys(f.path)
+	if err != nil {
+		return err
+	}
+	f.keys, f.modTime = keys, info.ModTime()
+	return nil
+}
+
+// LoadPrivateKey reads a base64 ed25519 seed, as written by
+// EncodePrivateKey.
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid private key file %s", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// EncodePrivateKey encodes key for LoadPrivateKey.
+func EncodePrivateKey(key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(key.Seed())
+}
+
+// WithSigner signs the message with the ed25519 private key of name so
+// subscribers using WithVerification can check it was published by name. A
+// nil key leaves the message unsigned.
+func WithSigner(name string, key ed25519.PrivateKey) PublishOption {
+	return func(o *publishOptions) {
+		if key == nil {
+			return
+		}
+		o.signer = name
+		o.signingKey = key
+	}
+}
+
+// WithVerification dead-letters deliveries that are unsigned, whose
+// signature does not match the signer's key in keys, or whose signer is not
+// the player claimed returns for them. A nil keys verifies nothing.
+func WithVerification(keys Keyring, claimed KeyFunc) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.keys = keys
+		o.claimed = claimed
+	}
+}
+
+// sign adds the headers signing msg as signer to headers.
+func sign(msg Message, key string, signer string, signingKey ed25519.PrivateKey, headers map[string]any) {
+	headers[signerHeader] = signer
+	headers[signatureHeader] = hex.EncodeToString(ed25519.Sign(signingKey, digest(msg, key, signer)))
+}
+
+// verify checks the signature of d and returns who signed it.
+func verify(d Delivery, keys Keyring) (string, error) {
+	signer, _ := d.Headers[signerHeader].(string)
+	sig, _ := d.Headers[signatureHeader].(string)
+	if signer == "" || sig == "" {
+		return "", errors.New("message is not signed")
+	}
+	publicKey, ok := keys.Key(signer)
+	if !ok {
+		return "", fmt.Errorf("no key for signer %q", signer)
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return "", ErrBadSignature
+	}
+	// Retried messages come back through the default exchange, so check
+	// them against the routing key they were first published with.
+	key := d.RoutingKey
+	if original, ok := d.Headers[originalRoutingKeyHeader].(string); ok {
+		key = original
+	}
+	if !ed25519.Verify(publicKey, digest(d.Message, key, signer), got) {
+		return "", ErrBadSignature
+	}
+	return signer, nil
+}
+
+// digest covers the routing key, the envelope, the RPC correlation ID and
+// error, and the body as sent, so a signed message cannot be altered,
+// published for another player or replayed as the reply to another call.
+func digest(msg Message, key string, signer string) []byte {
+	h := sha256.New()
+	for _, field := range []string{
+		signer,
+		key,
+		msg.Type,
+		msg.MessageID,
+		strconv.FormatInt(msg.Timestamp.Unix(), 10),
+		msg.ContentType,
+		msg.ContentEncoding,
+		msg.CorrelationID,
+		rpcError(msg),
+	} {
+		writeField(h, []byte(field))
+	}
+	writeField(h, msg.Body)
+	return h.Sum(nil)
+}
+
+// writeField writes data with its length so fields cannot run into each
+// other.
+func writeField(h hash.Hash, data []byte) {
+	h.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	h.Write(data)
+}
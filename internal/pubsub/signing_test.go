@@ -0,0 +1,129 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newKey(t *testing.T, keys Keys, name string) ed25519.PrivateKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys[name] = public
+	return private
+}
+
+func TestVerificationDeadLettersForgeries(t *testing.T) {
+	b := newTestBroker(t)
+	keys := Keys{}
+	alice := newKey(t, keys, "alice")
+	bob := newKey(t, keys, "bob")
+	got := make(chan testMove, 4)
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		got <- mv
+		return Ack, nil
+	}, WithVerification(keys, ByValue(func(mv testMove) string { return mv.Player })))
+
+	forged := []struct {
+		name string
+		opts []PublishOption
+	}{
+		{"unsigned", nil},
+		{"signed by bob for alice", []PublishOption{WithSigner("bob", bob)}},
+		{"signed as bob with another key", []PublishOption{WithSigner("bob", alice)}},
+		{"signed by an unknown player", []PublishOption{WithSigner("mallory", alice)}},
+	}
+	for _, f := range forged {
+		if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice", Seq: 1}, f.opts...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice", Seq: 2}, WithSigner("alice", alice)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case mv := <-got:
+		if mv.Seq != 2 {
+			t.Errorf("handled forged move %+v", mv)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the genuine move was not handled")
+	}
+	letters := deadLetters(t, b, len(forged))
+	if len(letters) != len(forged) {
+		t.Errorf("got %d dead letters, want %d", len(letters), len(forged))
+	}
+	for _, dl := range letters {
+		if dl.Reason != "rejected" {
+			t.Errorf("got reason %q, want rejected", dl.Reason)
+		}
+	}
+}
+
+func TestVerificationIsOptIn(t *testing.T) {
+	b := newTestBroker(t)
+	got := make(chan testMove, 1)
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		got <- mv
+		return Ack, nil
+	}, WithVerification(nil, ByRoutingKey))
+
+	if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice"}, WithSigner("alice", nil)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-got:
+	case <-time.After(testTimeout):
+		t.Fatal("unsigned move was not handled without keys")
+	}
+}
+
+func TestKeyFileReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	keys := Keys{}
+	newKey(t, keys, "alice")
+	write := func() {
+		t.Helper()
+		data, err := json.Marshal(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write()
+	f, err := OpenKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.Key("bob"); ok {
+		t.Fatal("found a key for bob before it was added")
+	}
+
+	newKey(t, keys, "bob")
+	write()
+	// Changes are only looked for once keysCheckInterval has passed.
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Second))
+	f.checked = time.Time{}
+	if key, ok := f.Key("bob"); !ok || !key.Equal(keys["bob"]) {
+		t.Error("did not pick up the key added for bob")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second))
+	f.checked = time.Time{}
+	if _, ok := f.Key("alice"); !ok {
+		t.Error("a broken keys file dropped the keys already read")
+	}
+}
@@ -0,0 +1,319 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// Subscription is a running consumer started by Subscribe, SubscribeJSON
+// or SubscribeGob.
+type Subscription struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close stops the consumer and waits for the handlers that are running to
+// finish and settle their deliveries.
+func (s *Subscription) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// Done is closed once the subscription has stopped, either through Close or
+// because its context was cancelled.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+const defaultPrefetch = 10
+
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	prefetch   int
+	workers    int
+	orderKey   KeyFunc
+	retry      RetryPolicy
+	codec      Codec
+	keys       Keyring
+	claimed    KeyFunc
+	dedup      Deduplicator
+	middleware []Middleware
+	reply      []PublishOption
+}
+
+// WithPrefetch sets how many unacknowledged deliveries the broker may push
+// to the subscription at once. It defaults to 10, or to the number of
+// workers if that is larger.
+func WithPrefetch(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.prefetch = n
+	}
+}
+
+// WithWorkers runs the handler on n goroutines so slow handlers can process
+// deliveries in parallel. Each worker settles its own delivery.
+func WithWorkers(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.workers = n
+	}
+}
+
+// WithDefaultCodec decodes deliveries that carry no content type with codec.
+func WithDefaultCodec(codec Codec) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.codec = codec
+	}
+}
+
+// WithReplyOptions publishes the replies of Serve with opts, such as
+// WithSigner so callers can verify them.
+func WithReplyOptions(opts ...PublishOption) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.reply = append(o.reply, opts...)
+	}
+}
+
+// KeyFunc picks the ordering key for a delivery from the delivery itself or
+// its decoded value.
+type KeyFunc func(d Delivery, val any) string
+
+// ByRoutingKey orders deliveries that share a routing key.
+func ByRoutingKey(d Delivery, _ any) string {
+	return d.RoutingKey
+}
+
+// ByValue orders deliveries by a key taken from the decoded value.
+func ByValue[T any](key func(T) string) KeyFunc {
+	return func(_ Delivery, val any) string {
+		v, _ := val.(T)
+		return key(v)
+	}
+}
+
+// WithOrderedKey shards deliveries across the workers by key, so deliveries
+// with the same key are handled one at a time in the order they arrived
+// while different keys still run in parallel.
+func WithOrderedKey(key KeyFunc) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.orderKey = key
+	}
+}
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+	o := subscribeOptions{workers: 1, retry: DefaultRetryPolicy}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.workers = max(o.workers, 1)
+	if o.prefetch <= 0 {
+		o.prefetch = max(defaultPrefetch, o.workers)
+	}
+	return o
+}
+
+type decoded[T any] struct {
+	msg Delivery
+	val T
+}
+
+func subscribe[T any](
+	ctx context.Context,
+	b Broker,
+	exchange,
+	queueName,
+	key string,
+	simpleQueueType SimpleQueueType,
+	handler Handler,
+	opts []SubscribeOption,
+) (*Subscription, error) {
+	err := b.DeclareAndBind(exchange, queueName, key, simpleQueueType)
+	if err != nil {
+		return nil, fmt.Errorf("could not declare and bind queue %s: %w", queueName, err)
+	}
+	return subscribeQueue[T](ctx, b, queueName, simpleQueueType == Durable, handler, opts)
+}
+
+// subscribeQueue handles the messages of the already declared queueName until ctx
+// is done or the subscription is closed.
+func subscribeQueue[T any](
+	ctx context.Context,
+	b Broker,
+	queueName string,
+	durable bool,
+	handler Handler,
+	opts []SubscribeOption,
+) (*Subscription, error) {
+	o := newSubscribeOptions(opts)
+	ctx, cancel := context.WithCancel(ctx)
+	msgs, err := b.Consume(ctx, queueName, o.prefetch)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	sub := &Subscription{cancel: cancel, done: make(chan struct{})}
+	settler := &settler{b: b, queueName: queueName, durable: durable, retry: o.retry}
+	handler = chain(handler, o.middleware)
+	var workers sync.WaitGroup
+	if o.orderKey == nil {
+		for i := 0; i < o.workers; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for msg := range msgs {
+					deliveredTotal.inc(queueName)
+					if val, ok := decode[T](queueName, msg, o); ok {
+						handle(ctx, msg, val, handler, settler, o.dedup)
+					}
+				}
+			}()
+		}
+	} else {
+		// Every shard can hold the whole prefetch window, so the dispatcher
+		// never waits on a busy shard while another one is idle.
+		shards := make([]chan decoded[T], o.workers)
+		for i := range shards {
+			shards[i] = make(chan decoded[T], o.prefetch)
+			workers.Add(1)
+			go func(shard <-chan decoded[T]) {
+				defer workers.Done()
+				for d := range shard {
+					handle(ctx, d.msg, d.val, handler, settler, o.dedup)
+				}
+			}(shards[i])
+		}
+		go func() {
+			defer func() {
+				for _, shard := range shards {
+					close(shard)
+				}
+			}()
+			for msg := range msgs {
+				deliveredTotal.inc(queueName)
+				val, ok := decode[T](queueName, msg, o)
+				if !ok {
+					continue
+				}
+				shards[shardFor(o.orderKey(msg, val), len(shards))] <- decoded[T]{msg: msg, val: val}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(sub.done)
+	}()
+	return sub, nil
+}
+
+// valueOnly adapts a handler that does not need the delivery itself.
+func valueOnly[T any](handler func(T) AckType) Handler {
+	return func(_ context.Context, _ Delivery, val any) (AckType, error) {
+		return handler(val.(T)), nil
+	}
+}
+
+// valueOnlyErr is valueOnly for handlers that return an error and take the
+// handler's context.
+func valueOnlyErr[T any](handler func(context.Context, T) (AckType, error)) Handler {
+	return func(ctx context.Context, _ Delivery, val any) (AckType, error) {
+		return handler(ctx, val.(T))
+	}
+}
+
+func shardFor(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// decode dead-letters deliveries it cannot decode or verify, so they can be
+// inspected instead of silently disappearing.
+func decode[T any](queueName string, msg Delivery, o subscribeOptions) (T, bool) {
+	var zero T
+	var signer string
+	if o.keys != nil {
+		var err error
+		signer, err = verify(msg, o.keys)
+		if err != nil {
+			log.Printf("Rejected message: %v", err)
+			msg.Nack(false)
+			return zero, false
+		}
+	}
+	decompressed, err := Decompress(msg.Message)
+	if err != nil {
+		decodeFailuresTotal.inc(queueName)
+		log.Printf("Failed to unmarshal message: %v", err)
+		msg.Nack(false)
+		return zero, false
+	}
+	codec := o.codec
+	if msg.ContentType != "" || codec == nil {
+		codec, err = CodecFor(msg.ContentType)
+		if err != nil {
+			decodeFailuresTotal.inc(queueName)
+			log.Printf("Failed to unmarshal message: %v", err)
+			msg.Nack(false)
+			return zero, false
+		}
+	}
+	val, err := unmarshal[T](codec, decompressed)
+	if err != nil {
+		decodeFailuresTotal.inc(queueName)
+		log.Printf("Failed to unmarshal message: %v", err)
+		msg.Nack(false)
+		return zero, false
+	}
+	if o.keys != nil {
+		if claimed := o.claimed(msg, val); claimed != signer {
+			log.Printf("Rejected message signed by %q on behalf of %q", signer, claimed)
+			msg.Nack(false)
+			return zero, false
+		}
+	}
+	return val, true
+}
+
+func handle(ctx context.Context, msg Delivery, val any, handler Handler, settler *settler, dedup Deduplicator) {
+	dedupe := dedup != nil && msg.MessageID != ""
+	if dedupe {
+		claimed, err := dedup.Claim(msg.MessageID)
+		if err != nil {
+			log.Printf("Failed to claim message %s: %v", msg.MessageID, err)
+		}
+		if !claimed {
+			log.Printf("Skipping duplicate message %s", msg.MessageID)
+			msg.Ack()
+			return
+		}
+	}
+	// The delivery is handled to the end once started, even while the
+	// subscription drains.
+	ctx = contextWithLineage(context.WithoutCancel(ctx), msg.Lineage())
+	ctx, span := startConsumerSpan(ctx, "handle "+settler.queueName, msg.Message)
+	span.Attributes["queue"] = settler.queueName
+	span.Attributes["routing_key"] = msg.RoutingKey
+	span.Attributes["message_id"] = msg.MessageID
+	start := time.Now()
+	acktype, err := handler(ctx, msg, val)
+	handlerSeconds.observe(settler.queueName, time.Since(start))
+	acktype = ackFor(acktype, err)
+	span.Attributes["ack"] = acktype.String()
+	span.end(err)
+	// Only handled messages are recorded; anything else may come back to be
+	// tried again.
+	if dedupe && acktype == Ack {
+		if err := dedup.Commit(msg.MessageID); err != nil {
+			log.Printf("Failed to record message %s: %v", msg.MessageID, err)
+		}
+	} else if dedupe {
+		if err := dedup.Release(msg.MessageID); err != nil {
+			log.Printf("Failed to release message %s: %v", msg.MessageID, err)
+		}
+	}
+	settler.settle(msg, acktype, err)
+}
@@ -0,0 +1,132 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMove struct {
+	Player string
+	Seq    int
+}
+
+// subscribeMoves subscribes handler to every move published to the test
+// exchange.
+func subscribeMoves(t *testing.T, b Broker, handler func(context.Context, testMove) (AckType, error), opts ...SubscribeOption) {
+	t.Helper()
+	sub, err := SubscribeJSONErr(context.Background(), b, testExchange, "moves", "army_moves.*", Durable, handler, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Close)
+}
+
+func TestShardFor(t *testing.T) {
+	for _, key := range []string{"army_moves.alice", "army_moves.bob", ""} {
+		shard := shardFor(key, 4)
+		if shard < 0 || shard >= 4 {
+			t.Errorf("shardFor(%q, 4) = %d, out of range", key, shard)
+		}
+		if again := shardFor(key, 4); again != shard {
+			t.Errorf("shardFor(%q, 4) = %d then %d", key, shard, again)
+		}
+	}
+}
+
+func TestSubscribeOrderedKeyKeepsOrderPerKey(t *testing.T) {
+	const perPlayer = 50
+	players := []string{"alice", "bob"}
+	b := newTestBroker(t)
+
+	var mu sync.Mutex
+	seen := map[string][]int{}
+	var wg sync.WaitGroup
+	wg.Add(len(players) * perPlayer)
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		// Every other move is slow, so the next one would overtake it on
+		// another worker.
+		if mv.Seq%2 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		seen[mv.Player] = append(seen[mv.Player], mv.Seq)
+		mu.Unlock()
+		wg.Done()
+		return Ack, nil
+	}, WithWorkers(4), WithOrderedKey(ByRoutingKey))
+
+	for seq := 0; seq < perPlayer; seq++ {
+		for _, player := range players {
+			mv := testMove{Player: player, Seq: seq}
+			if err := PublishJSON(b, testExchange, "army_moves."+player, mv); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("moves were not handled")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, player := range players {
+		for i, seq := range seen[player] {
+			if seq != i {
+				t.Errorf("moves of %s handled in order %v", player, seen[player])
+				break
+			}
+		}
+	}
+}
+
+func TestSubscribeOrderedKeyByValue(t *testing.T) {
+	b := newTestBroker(t)
+	got := make(chan testMove, 10)
+	subscribeMoves(t, b, func(_ context.Context, mv testMove) (AckType, error) {
+		got <- mv
+		return Ack, nil
+	}, WithWorkers(2), WithOrderedKey(ByValue(func(mv testMove) string { return mv.Player })))
+
+	// Both moves share a player but not a routing key.
+	for i, key := range []string{"army_moves.a", "army_moves.b"} {
+		if err := PublishJSON(b, testExchange, key, testMove{Player: "alice", Seq: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for want := 0; want < 2; want++ {
+		select {
+		case mv := <-got:
+			if mv.Seq != want {
+				t.Errorf("got move %d, want %d", mv.Seq, want)
+			}
+		case <-time.After(testTimeout):
+			t.Fatal("moves were not handled")
+		}
+	}
+}
+
+func TestSubscribeReportsDeclareError(t *testing.T) {
+	b := newTestBroker(t)
+	// A queue already declared with other options cannot be declared again.
+	if err := b.DeclareQueue("moves", QueueOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	handler := func(context.Context, testMove) (AckType, error) { return Ack, nil }
+	_, err := SubscribeJSONErr(context.Background(), b, testExchange, "moves", "army_moves.*", Durable, handler)
+	if err == nil {
+		t.Error("subscribed to a queue that could not be declared")
+	}
+	_, err = SubscribeJSONErr(context.Background(), b, "peril_missing", "moves.missing", "army_moves.*", Durable, handler)
+	if err == nil {
+		t.Error("subscribed to a queue that could not be bound")
+	}
+}
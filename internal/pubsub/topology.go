@@ -0,0 +1,78 @@
+package pubsub
+
+import "fmt"
+
+// DefaultDeadLetterExchange is where queues declared by DeclareAndBind, and
+// retries that run out of attempts, send their dead letters.
+const DefaultDeadLetterExchange = "peril_dlx"
+
+const (
+	ExchangeDirect = "direct"
+	ExchangeTopic  = "topic"
+	ExchangeFanout = "fanout"
+)
+
+type Exchange struct {
+	Name string
+	Kind string
+}
+
+type Queue struct {
+	Name    string
+	Options QueueOptions
+}
+
+type Binding struct {
+	Queue    string
+	Key      string
+	Exchange string
+}
+
+// Topology describes exchanges, queues and bindings that must exist before
+// anything is published or consumed.
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+	Bindings  []Binding
+}
+
+// DeclareTopology declares exchanges, then queues, then bindings. Each
+// declaration is idempotent, so it is safe to run on every startup.
+func DeclareTopology(b Broker, t Topology) error {
+	for _, ex := range t.Exchanges {
+		if err := b.DeclareExchange(ex.Name, ex.Kind); err != nil {
+			return fmt.Errorf("could not declare exchange %s: %w", ex.Name, err)
+		}
+	}
+	for _, q := range t.Queues {
+		if err := b.DeclareQueue(q.Name, q.Options); err != nil {
+			return fmt.Errorf("could not declare queue %s: %w", q.Name, err)
+		}
+	}
+	for _, binding := range t.Bindings {
+		if err := b.BindQueue(binding.Queue, binding.Key, binding.Exchange); err != nil {
+			return fmt.Errorf("could not bind queue %s to %s: %w", binding.Queue, binding.Exchange, err)
+		}
+	}
+	return nil
+}
+
+// merge adds to t whatever in other it does not already contain.
+func (t *Topology) merge(other Topology) {
+	t.Exchanges = appendMissing(t.Exchanges, other.Exchanges)
+	t.Queues = appendMissing(t.Queues, other.Queues)
+	t.Bindings = appendMissing(t.Bindings, other.Bindings)
+}
+
+func appendMissing[T comparable](existing, more []T) []T {
+outer:
+	for _, m := range more {
+		for _, e := range existing {
+			if e == m {
+				continue outer
+			}
+		}
+		existing = append(existing, m)
+	}
+	return existing
+}
@@ -0,0 +1,189 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// traceparentHeader carries the W3C trace context of the span that
+// published a message.
+const traceparentHeader = "traceparent"
+
+// Span is a finished unit of work as given to a SpanExporter. Every publish
+// and every handled delivery is a span; a handler's span is the child of
+// the span that published its delivery, so spans sharing a TraceID can be
+// followed from process to process.
+type Span struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// SpanExporter receives every span when it ends.
+type SpanExporter interface {
+	ExportSpan(Span) error
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   SpanExporter
+)
+
+// SetSpanExporter exports the spans that end from now on to e. Trace
+// context is propagated whether or not an exporter is set.
+func SetSpanExporter(e SpanExporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+// NewSpanWriter returns an exporter writing one JSON object per span to w,
+// such as os.Stdout or an open file.
+func NewSpanWriter(w io.Writer) SpanExporter {
+	return &spanWriter{enc: json.NewEncoder(w)}
+}
+
+type spanWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (w *spanWriter) ExportSpan(s Span) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(s)
+}
+
+// WithContext publishes as part of the trace in ctx, such as the ctx given
+// to a handler, and stops waiting for a confirm when ctx is done.
+func WithContext(ctx context.Context) PublishOption {
+	return func(o *publishOptions) {
+		o.ctx = ctx
+	}
+}
+
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	flags   byte
+}
+
+func (sc spanContext) valid() bool {
+	return sc.traceID != [16]byte{} && sc.spanID != [8]byte{}
+}
+
+func (sc spanContext) traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", sc.traceID, sc.spanID, sc.flags)
+}
+
+// parseTraceparent reads a version 00 traceparent header.
+func parseTraceparent(s string) (spanContext, bool) {
+	var sc spanContext
+	if len(s) != 55 || s[:3] != "00-" || s[35] != '-' || s[52] != '-' {
+		return sc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.traceID[:], []byte(s[3:35])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(s[36:52])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:])); err != nil {
+		return sc, false
+	}
+	sc.flags = flags[0]
+	return sc, sc.valid()
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(spanKey{}).(spanContext)
+	return sc, ok
+}
+
+// span is a span in progress.
+type span struct {
+	Span
+}
+
+// startSpan starts a child of the span in ctx, or a new trace if there is
+// none, and returns ctx with the new span in it.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	parent, ok := spanFromContext(ctx)
+	sc := spanContext{traceID: parent.traceID, flags: 1}
+	if ok {
+		sc.flags = parent.flags
+	} else {
+		rand.Read(sc.traceID[:])
+	}
+	rand.Read(sc.spanID[:])
+	s := &span{Span{
+		Name:       name,
+		TraceID:    hex.EncodeToString(sc.traceID[:]),
+		SpanID:     hex.EncodeToString(sc.spanID[:]),
+		Start:      time.Now(),
+		Attributes: map[string]string{},
+	}}
+	if ok {
+		s.ParentID = hex.EncodeToString(parent.spanID[:])
+	}
+	return context.WithValue(ctx, spanKey{}, sc), s
+}
+
+func startPublishSpan(ctx context.Context, exchange, key string, msg Message) (context.Context, *span) {
+	ctx, s := startSpan(ctx, "publish "+key)
+	s.Attributes["exchange"] = exchange
+	s.Attributes["routing_key"] = key
+	s.Attributes["message_id"] = msg.MessageID
+	return ctx, s
+}
+
+// startConsumerSpan starts the span handling msg as a child of the span
+// that published it.
+func startConsumerSpan(ctx context.Context, name string, msg Message) (context.Context, *span) {
+	if tp, ok := msg.Headers[traceparentHeader].(string); ok {
+		if sc, ok := parseTraceparent(tp); ok {
+			ctx = context.WithValue(ctx, spanKey{}, sc)
+		}
+	}
+	return startSpan(ctx, name)
+}
+
+func (s *span) end(err error) {
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	exporterMu.RLock()
+	e := exporter
+	exporterMu.RUnlock()
+	if e == nil {
+		return
+	}
+	// Losing a span must not fail the message it describes.
+	if err := e.ExportSpan(s.Span); err != nil {
+		log.Printf("could not export span %s: %v", s.Name, err)
+	}
+}
+
+// injectTrace adds the header recording the span in ctx as the publisher
+// of a message to headers.
+func injectTrace(ctx context.Context, headers map[string]any) {
+	if sc, ok := spanFromContext(ctx); ok {
+		headers[traceparentHeader] = sc.traceparent()
+	}
+}
@@ -0,0 +1,102 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := parseTraceparent(tp)
+	if !ok {
+		t.Fatalf("could not parse %s", tp)
+	}
+	if sc.flags != 1 {
+		t.Errorf("got flags %02x, want 01", sc.flags)
+	}
+	if got := sc.traceparent(); got != tp {
+		t.Errorf("wrote %s back as %s", tp, got)
+	}
+
+	for _, bad := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		if _, ok := parseTraceparent(bad); ok {
+			t.Errorf("parsed invalid traceparent %q", bad)
+		}
+	}
+}
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func (r *spanRecorder) ExportSpan(s Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+	return nil
+}
+
+func TestTracePropagates(t *testing.T) {
+	rec := &spanRecorder{}
+	SetSpanExporter(rec)
+	t.Cleanup(func() { SetSpanExporter(nil) })
+
+	b := newTestBroker(t)
+	done := make(chan struct{})
+	// The first move's handler publishes the second as part of its trace.
+	sub, err := SubscribeJSONErr(context.Background(), b, testExchange, "moves", "army_moves.*", Durable, func(ctx context.Context, mv testMove) (AckType, error) {
+		if mv.Seq == 1 {
+			close(done)
+			return Ack, nil
+		}
+		return Ack, PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice", Seq: 1}, WithContext(ctx))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishJSON(b, testExchange, "army_moves.alice", testMove{Player: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("moves were not handled")
+	}
+	// Closing waits for the last handler span to end.
+	sub.Close()
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	want := []string{"publish army_moves.alice", "handle moves", "publish army_moves.alice", "handle moves"}
+	if len(rec.spans) != len(want) {
+		t.Fatalf("got %d spans, want %d", len(rec.spans), len(want))
+	}
+	// A handler's publish ends before its handler span does.
+	spans := []Span{rec.spans[0], rec.spans[2], rec.spans[1], rec.spans[3]}
+	for i, s := range spans {
+		if s.Name != want[i] {
+			t.Errorf("span %d is %q, want %q", i, s.Name, want[i])
+		}
+		if s.TraceID != spans[0].TraceID {
+			t.Errorf("span %q has trace %s, want %s", s.Name, s.TraceID, spans[0].TraceID)
+		}
+		if i == 0 {
+			if s.ParentID != "" {
+				t.Errorf("first publish has parent %s", s.ParentID)
+			}
+		} else if s.ParentID != spans[i-1].SpanID {
+			t.Errorf("span %q has parent %s, want %s", s.Name, s.ParentID, spans[i-1].SpanID)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package routing
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+const (
+	// KeysFileEnv overrides the path of the public keys file shared by the
+	// server and the clients.
+	KeysFileEnv     = "PERIL_KEYS"
+	DefaultKeysFile = "peril_keys.json"
+
+	// PrivateKeyEnv overrides the path of this process's own private key,
+	// which defaults to the signer's name with a .key extension.
+	PrivateKeyEnv = "PERIL_PRIVATE_KEY"
+
+	// ServerSigner is the keys file entry the server signs its messages with.
+	ServerSigner = "peril-server"
+)
+
+// KeysFile is the path of the public keys file.
+func KeysFile() string {
+	if path := os.Getenv(KeysFileEnv); path != "" {
+		return path
+	}
+	return DefaultKeysFile
+}
+
+// LoadKeys reads the public keys of the server and every player, and the
+// private key this process signs with as signer. Signing is opt-in: without
+// a keys file both are nil, so messages are sent unsigned and accepted
+// unverified.
+func LoadKeys(signer string) (pubsub.Keyring, ed25519.PrivateKey, error) {
+	keys, err := pubsub.OpenKeys(KeysFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := LoadPrivateKey(signer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load private key of %s: %w", signer, err)
+	}
+	return keys, key, nil
+}
+
+// PrivateKeyFile is the default private key file of signer.
+func PrivateKeyFile(signer string) string {
+	return signer + ".key"
+}
+
+// LoadPrivateKey reads the private key this process signs with as signer.
+func LoadPrivateKey(signer string) (ed25519.PrivateKey, error) {
+	path := os.Getenv(PrivateKeyEnv)
+	if path == "" {
+		path = PrivateKeyFile(signer)
+	}
+	return pubsub.LoadPrivateKey(path)
+}
+
+// SignedByServer claims every delivery was published by the server.
+func SignedByServer(pubsub.Delivery, any) string {
+	return ServerSigner
+}
@@ -0,0 +1,43 @@
+package routing
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+func TestLoadKeysIsOptIn(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(KeysFileEnv, filepath.Join(dir, "keys.json"))
+	t.Setenv(PrivateKeyEnv, filepath.Join(dir, "alice.key"))
+
+	keys, key, err := LoadKeys("alice")
+	if err != nil || keys != nil || key != nil {
+		t.Fatalf("without a keys file got %v, %v, %v, want nothing", keys, key, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "keys.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadKeys("alice"); err == nil {
+		t.Error("loaded keys without the private key of alice")
+	}
+
+	_, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "alice.key"), []byte(pubsub.EncodePrivateKey(private)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, key, err = LoadKeys("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys == nil || !key.Equal(private) {
+		t.Error("did not load the keys")
+	}
+}
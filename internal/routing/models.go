@@ -1,9 +1,33 @@
 package routing
 
-import "time"
+import (
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// Schema versions of the message types. Bump one when its struct changes
+// and register an upgrader from the previous version.
+const (
+	PlayingStateVersion = 1
+	GameLogVersion      = 1
+)
+
+func init() {
+	pubsub.RegisterType[PlayingState]("peril.PlayingState", PlayingStateVersion)
+	pubsub.RegisterType[GameLog]("peril.GameLog", GameLogVersion)
+}
 
 type PlayingState struct {
 	IsPaused bool
+	// Seq numbers the server's pause state changes, so a client can tell an
+	// old state from a newer one it has already applied. Zero means unknown.
+	Seq uint64
+}
+
+// PlayingStateRequest asks the server for the current PlayingState.
+type PlayingStateRequest struct {
+	Username string
 }
 
 type GameLog struct {
@@ -0,0 +1,41 @@
+package routing
+
+import (
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/perilpb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (ps PlayingState) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&perilpb.PlayingState{IsPaused: ps.IsPaused, Seq: ps.Seq})
+}
+
+func (ps *PlayingState) UnmarshalProto(data []byte) error {
+	var pb perilpb.PlayingState
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	*ps = PlayingState{IsPaused: pb.GetIsPaused(), Seq: pb.GetSeq()}
+	return nil
+}
+
+func (gl GameLog) MarshalProto() ([]byte, error) {
+	return proto.Marshal(&perilpb.GameLog{
+		CurrentTime: timestamppb.New(gl.CurrentTime),
+		Message:     gl.Message,
+		Username:    gl.Username,
+	})
+}
+
+func (gl *GameLog) UnmarshalProto(data []byte) error {
+	var pb perilpb.GameLog
+	if err := proto.Unmarshal(data, &pb); err != nil {
+		return err
+	}
+	*gl = GameLog{
+		CurrentTime: pb.GetCurrentTime().AsTime(),
+		Message:     pb.GetMessage(),
+		Username:    pb.GetUsername(),
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	ps := PlayingState{IsPaused: true, Seq: 42}
+	gl := GameLog{
+		CurrentTime: time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC),
+		Message:     "alice won a war against bob",
+		Username:    "alice",
+	}
+	for _, codec := range []pubsub.Codec{pubsub.MsgPack, pubsub.Protobuf} {
+		data, err := codec.Marshal(ps)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		var gotPS PlayingState
+		if err := codec.Unmarshal(data, &gotPS); err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		if gotPS != ps {
+			t.Errorf("%s: got %+v, want %+v", codec.ContentType(), gotPS, ps)
+		}
+
+		data, err = codec.Marshal(gl)
+		if err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		var gotGL GameLog
+		if err := codec.Unmarshal(data, &gotGL); err != nil {
+			t.Fatalf("%s: %v", codec.ContentType(), err)
+		}
+		if !gotGL.CurrentTime.Equal(gl.CurrentTime) || gotGL.Message != gl.Message || gotGL.Username != gl.Username {
+			t.Errorf("%s: got %+v, want %+v", codec.ContentType(), gotGL, gl)
+		}
+	}
+}
@@ -8,6 +8,10 @@ const (
 	PauseKey = "pause"
 
 	GameLogSlug = "game_logs"
+
+	// PlayingStateRPC is the routing key and queue of the server's answers
+	// to PlayingStateRequest.
+	PlayingStateRPC = "rpc.playing_state"
 )
 
 const (
@@ -0,0 +1,25 @@
+package routing
+
+import "github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+
+const (
+	ExchangePerilDLX = pubsub.DefaultDeadLetterExchange
+	QueuePerilDLQ    = "peril_dlq"
+)
+
+// Topology is everything Peril expects to exist on the broker. Both the
+// server and the client declare it at startup so a fresh broker works
+// without any setup in the management UI.
+var Topology = pubsub.Topology{
+	Exchanges: []pubsub.Exchange{
+		{Name: ExchangePerilDirect, Kind: pubsub.ExchangeDirect},
+		{Name: ExchangePerilTopic, Kind: pubsub.ExchangeTopic},
+		{Name: ExchangePerilDLX, Kind: pubsub.ExchangeFanout},
+	},
+	Queues: []pubsub.Queue{
+		{Name: QueuePerilDLQ, Options: pubsub.QueueOptions{Durable: true}},
+	},
+	Bindings: []pubsub.Binding{
+		{Queue: QueuePerilDLQ, Key: "", Exchange: ExchangePerilDLX},
+	},
+}
@@ -0,0 +1,30 @@
+// Package telemetry sets up the metrics endpoint and span export of the
+// Peril commands from their environment.
+package telemetry
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// MetricsAddrEnv is the address to serve /metrics on, such as ":9100". The
+// endpoint is off when it is unset.
+const MetricsAddrEnv = "PERIL_METRICS_ADDR"
+
+// ServeMetrics serves the pubsub metrics in the background if MetricsAddrEnv
+// is set.
+func ServeMetrics() {
+	addr := os.Getenv(MetricsAddrEnv)
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", pubsub.MetricsHandler())
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		log.Printf("could not serve metrics on %s: %v", addr, err)
+	}()
+}
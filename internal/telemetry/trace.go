@@ -0,0 +1,31 @@
+package telemetry
+
+import (
+	"os"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+)
+
+// TracesEnv exports spans to stdout if set to "stdout", or appends them to
+// the file it names otherwise. Nothing is exported when it is unset.
+const TracesEnv = "PERIL_TRACES"
+
+// ExportTraces sets the span exporter chosen by TracesEnv. The returned
+// function closes the exporter's file, if it has one.
+func ExportTraces() (func() error, error) {
+	noop := func() error { return nil }
+	path := os.Getenv(TracesEnv)
+	switch path {
+	case "":
+		return noop, nil
+	case "stdout":
+		pubsub.SetSpanExporter(pubsub.NewSpanWriter(os.Stdout))
+		return noop, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	pubsub.SetSpanExporter(pubsub.NewSpanWriter(f))
+	return f.Close, nil
+}